@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // TODO: Create Stripe style sdk
@@ -17,42 +22,363 @@ import (
 
 type AdminClient struct {
 		Endpoint		string
+		// AdminSecret is sent as X-Hasura-Admin-Secret when set.
 		AdminSecret string
+		// Headers are applied to every request, in order, after AdminSecret.
+		// Requests made with a context from WithHeaders apply the providers of
+		// the context last, so they can override these.
+		Headers			[]HeaderProvider
+
+		// HTTPClient sends the requests, which allows setting timeouts, proxies
+		// or TLS configuration. When nil, a client using Transport is used.
+		HTTPClient	*http.Client
+		// Transport is used when HTTPClient is nil, http.DefaultTransport if
+		// both are nil.
+		Transport		http.RoundTripper
+
+		// PartialData makes the generated methods return the fields that
+		// resolved along with the GraphQLErrors of the others, instead of only
+		// the errors.
+		PartialData	bool
+
+		// ValidateInputs makes the generated methods validate their variables,
+		// returning a ValidationError instead of sending them if they are
+		// invalid.
+		ValidateInputs	bool
+
+		// Retry makes requests retry transient failures. Mutations are only
+		// retried with a context from RetrySafe.
+		Retry				*RetryPolicy
+
+		// Subscriptions configures the WebSocket connections of subscriptions.
+		Subscriptions	SubscriptionOptions
+}
+
+func (c *AdminClient) httpClient() *http.Client {
+		if c.HTTPClient != nil {
+				return c.HTTPClient
+		}
+
+		return &http.Client{Transport: c.Transport}
+}
+
+// HeaderProvider sets headers on the requests of a client, such as the
+// credentials to authenticate with.
+type HeaderProvider interface {
+		SetHeaders(ctx context.Context, header http.Header) error
+}
+
+// HeaderProviderFunc adapts a function to a HeaderProvider.
+type HeaderProviderFunc func(ctx context.Context, header http.Header) error
+
+func (f HeaderProviderFunc) SetHeaders(ctx context.Context, header http.Header) error {
+		return f(ctx, header)
+}
+
+// StaticHeaders sets the same headers on every request, replacing previous
+// values of these headers.
+type StaticHeaders map[string]string
+
+func (h StaticHeaders) SetHeaders(ctx context.Context, header http.Header) error {
+		for name, value := range h {
+				header.Set(name, value)
+		}
+		return nil
+}
+
+// BearerToken authenticates requests with a fixed bearer token.
+type BearerToken string
+
+func (t BearerToken) SetHeaders(ctx context.Context, header http.Header) error {
+		header.Set("Authorization", "Bearer " + string(t))
+		return nil
+}
+
+// HasuraRole makes Hasura run requests as Role, on behalf of UserID if set.
+type HasuraRole struct {
+		Role		string
+		UserID	string
+}
+
+func (r HasuraRole) SetHeaders(ctx context.Context, header http.Header) error {
+		if r.Role != "" {
+				header.Set("X-Hasura-Role", r.Role)
+		}
+		if r.UserID != "" {
+				header.Set("X-Hasura-User-Id", r.UserID)
+		}
+		return nil
+}
+
+// TokenSource authenticates requests with bearer tokens obtained from Fetch,
+// which is called again once the current token expires. A zero expiry means
+// the token never expires.
+type TokenSource struct {
+		Fetch		func(ctx context.Context) (token string, expiry time.Time, err error)
+		// Leeway renews tokens this long before they expire.
+		Leeway	time.Duration
+
+		mu			sync.Mutex
+		token		string
+		expiry	time.Time
+}
+
+func (s *TokenSource) SetHeaders(ctx context.Context, header http.Header) error {
+		token, err := s.Token(ctx)
+		if err != nil {
+				return err
+		}
+
+		header.Set("Authorization", "Bearer " + token)
+		return nil
+}
+
+// Token returns the current token, fetching a new one if needed.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.token != "" && (s.expiry.IsZero() || time.Now().Add(s.Leeway).Before(s.expiry)) {
+				return s.token, nil
+		}
+
+		token, expiry, err := s.Fetch(ctx)
+		if err != nil {
+				return "", err
+		}
+
+		s.token, s.expiry = token, expiry
+		return token, nil
+}
+
+// Invalidate discards the current token, such as after the server rejected
+// it, so that the next request fetches a new one.
+func (s *TokenSource) Invalidate() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.token = ""
+}
+
+type headersContextKey struct{}
+
+// WithHeaders returns a context making the requests of a client also apply
+// providers, after the ones of the client and of ctx. This allows acting on
+// behalf of different users with a single client:
+//
+//		ctx = WithHeaders(ctx, HasuraRole{Role: "user", UserID: id})
+func WithHeaders(ctx context.Context, providers ...HeaderProvider) context.Context {
+		previous, _ := ctx.Value(headersContextKey{}).([]HeaderProvider)
+
+		return context.WithValue(ctx, headersContextKey{}, append(append([]HeaderProvider{}, previous...), providers...))
+}
+
+// setHeaders applies the headers of c and ctx to header.
+func (c *AdminClient) setHeaders(ctx context.Context, header http.Header) error {
+		if c.AdminSecret != "" {
+				header.Set("X-Hasura-Admin-Secret", c.AdminSecret)
+		}
+
+		providers, _ := ctx.Value(headersContextKey{}).([]HeaderProvider)
+		for _, provider := range append(append([]HeaderProvider{}, c.Headers...), providers...) {
+				if err := provider.SetHeaders(ctx, header); err != nil {
+						return err
+				}
+		}
+
+		return nil
 }
 
 type GraphQLVariables map[string]interface{}
 
-type GraphQLErrorExtensions struct {
-		Code string `json:"code"`
-		Path string `json:"path"`
+// ValidationError is returned by the Validate methods of inputs and variables
+// for a value the server would reject.
+type ValidationError struct {
+		// Path leads to the invalid value, as in $where._and[0].name.
+		Path		string
+		Message	string
+}
+
+func (e *ValidationError) Error() string {
+		return e.Path + ": " + e.Message
+}
+
+// validationErrorAt prefixes the path of a ValidationError from a nested input
+// with path, the path of the input.
+func validationErrorAt(path string, err error) error {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+				return err
+		}
+
+		nested := *validationErr
+		if strings.HasPrefix(nested.Path, "[") {
+				nested.Path = path + nested.Path
+		} else {
+				nested.Path = path + "." + nested.Path
+		}
+
+		return &nested
+}
+
+// NullVariables names the nullable variables of an operation that are sent as
+// null when their field is nil, instead of being omitted.
+type NullVariables []string
+
+func (null NullVariables) has(name string) bool {
+		for _, variable := range null {
+				if variable == name {
+						return true
+				}
+		}
+
+		return false
 }
 
+// GraphQLErrorLocation is a position in the query an error relates to.
+type GraphQLErrorLocation struct {
+		Line		int	`json:"line"`
+		Column	int	`json:"column"`
+}
+
+// GraphQLError is an error reported by the server, as described by the
+// GraphQL specification. Path holds the response keys (strings) and list
+// indices (numbers) leading to the field that failed. Extensions holds any
+// additional information, such as the code Hasura reports errors with.
 type GraphQLError struct {
 		Message			string									`json:"message"`
-		Extensions	GraphQLErrorExtensions	`json:"extensions"`
+		Locations		[]GraphQLErrorLocation	`json:"locations,omitempty"`
+		Path				[]interface{}						`json:"path,omitempty"`
+		Extensions	map[string]interface{}	`json:"extensions,omitempty"`
+}
+
+// Code returns the code extension of the error, or an empty string.
+func (e GraphQLError) Code() string {
+		code, _ := e.Extensions["code"].(string)
+		return code
+}
+
+// HasCode tells whether the code extension of the error is code.
+func (e GraphQLError) HasCode(code string) bool {
+		return e.Code() == code
+}
+
+// PathString returns Path in the a.b[0].c form, or an empty string for errors
+// that do not relate to a field.
+func (e GraphQLError) PathString() string {
+		var sb strings.Builder
+
+		for _, segment := range e.Path {
+				switch segment := segment.(type) {
+				case string:
+						if sb.Len() > 0 {
+								sb.WriteString(".")
+						}
+						sb.WriteString(segment)
+				case float64:
+						// encoding/json decodes numbers as float64, which %v prints in
+						// exponent form from 1e+06 on.
+						sb.WriteString("[" + strconv.FormatFloat(segment, 'f', -1, 64) + "]")
+				default:
+						sb.WriteString(fmt.Sprintf("[%v]", segment))
+				}
+		}
+
+		return sb.String()
+}
+
+func (e GraphQLError) Error() string {
+		var sb strings.Builder
+
+		if path := e.PathString(); path != "" {
+				sb.WriteString(path + ": ")
+		}
+		sb.WriteString(e.Message)
+		if code := e.Code(); code != "" {
+				sb.WriteString(" (" + code + ")")
+		}
+
+		return sb.String()
+}
+
+// Is makes errors.Is(err, ErrorCode(code)) match the errors with that code.
+func (e GraphQLError) Is(target error) bool {
+		code, ok := target.(ErrorCode)
+		return ok && e.HasCode(string(code))
+}
+
+// ErrorCode matches the GraphQLError with a code extension when given to
+// errors.Is:
+//
+//		if errors.Is(err, ErrorCode("constraint-violation")) { ... }
+type ErrorCode string
+
+func (code ErrorCode) Error() string {
+		return "graphql error " + string(code)
 }
 
 type GraphQLErrors []GraphQLError
 
 func (errs GraphQLErrors) Error() string {
-		var sb strings.Builder
-		sb.WriteString("ERROR\n")
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+				messages = append(messages, err.Error())
+		}
 
+		return strings.Join(messages, "\n")
+}
+
+// Is tells whether any of the errors matches target.
+func (errs GraphQLErrors) Is(target error) bool {
 		for _, err := range errs {
-				sb.WriteString(
-						fmt.Sprintf("%s ON %s -> %s", err.Extensions.Code, err.Extensions.Path, err.Message),
-				)
+				if errors.Is(err, target) {
+						return true
+				}
 		}
 
-		return sb.String()
+		return false
 }
 
+// As finds the first of the errors matching target, which allows getting a
+// single GraphQLError with errors.As.
+func (errs GraphQLErrors) As(target interface{}) bool {
+		for _, err := range errs {
+				if errors.As(err, target) {
+						return true
+				}
+		}
+
+		return false
+}
+
+// GraphQLResult is the response to a request. Data may be set alongside
+// Errors when only some fields failed to resolve.
 type GraphQLResult struct {
 		Data		json.RawMessage			`json:"data"`
 		Errors	GraphQLErrors				`json:"errors"`
 }
 
+// Request sends a query with its variables. When the response has errors, they
+// are returned as GraphQLErrors along with the response, whose Data holds the
+// fields that resolved, if any.
 func (c *AdminClient) Request(
+		ctx context.Context,
+		query string,
+		variables map[string]interface{},
+) (*GraphQLResult, error) {
+		operation := "query"
+		if isMutation(query) {
+				operation = "mutation"
+		}
+
+		return c.request(ctx, operation, query, variables)
+}
+
+// request is Request for a query whose operation type, query or mutation, is
+// known, as it is by generated methods.
+func (c *AdminClient) request(
+		ctx context.Context,
+		operation string,
 		query string,
 		variables map[string]interface{},
 ) (*GraphQLResult, error) {
@@ -64,41 +390,307 @@ func (c *AdminClient) Request(
 				return nil, err
 		}
 
-		request, err := http.NewRequest(
+		if c.Retry == nil || (operation == "mutation" && !isRetrySafe(ctx)) {
+				return c.send(ctx, body)
+		}
+
+		for attempt := 1; ; attempt++ {
+				result, err := c.send(ctx, body)
+
+				delay, retry := c.Retry.retries(ctx, attempt, err)
+				if !retry {
+						return result, err
+				}
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+						timer.Stop()
+						return result, err
+				case <-timer.C:
+				}
+		}
+}
+
+// send makes a single attempt at a request.
+func (c *AdminClient) send(ctx context.Context, body []byte) (*GraphQLResult, error) {
+		request, err := http.NewRequestWithContext(
+				ctx,
 				"POST",
 				c.Endpoint,
-				bytes.NewBuffer(body),
+				bytes.NewReader(body),
 		)
+		if err != nil {
+				return nil, err
+		}
 
 		request.Header.Set("Content-Type", "application/json")
-		request.Header.Set("X-Hasura-Admin-Secret", c.AdminSecret)
+		if err = c.setHeaders(ctx, request.Header); err != nil {
+				return nil, err
+		}
 
 		// bytes, err := httputil.DumpRequest(request, true)
 		// fmt.Printf("%s\n", string(bytes))
 
-		client := &http.Client{}
-		response, err := client.Do(request)
+		response, err := c.httpClient().Do(request)
 		if err != nil {
 				return nil, err
 		}
 		defer response.Body.Close()
 
-		body, err = ioutil.ReadAll(response.Body)
+		body, err = readResponse(response)
 		if err != nil {
 				return nil, err
 		}
 
 		var result GraphQLResult
 		err = json.Unmarshal(body, &result)
+		if err != nil {
+				return nil, newHTTPError(response, body, err)
+		}
+
+		if len(result.Errors) > 0 {
+				return &result, result.Errors
+		} else if len(result.Data) == 0 {
+				return nil, newHTTPError(response, body, errors.New("response has neither data nor errors"))
+		}
+
+		return &result, nil
+}
+
+// RetryPolicy tells which failed requests to retry and when. Network errors,
+// 429 and 5xx responses are retried, as well as GraphQL errors with one of
+// Codes. Retries are delayed by an exponential backoff with jitter, or by the
+// Retry-After header of the response when it has one, up to MaxBackoff.
+type RetryPolicy struct {
+		// MaxAttempts counts the first attempt, so 3, the default if zero, makes
+		// up to 2 retries. 1 disables retries.
+		MaxAttempts	int
+		// MinBackoff is the delay before the first retry, 100ms if zero. It
+		// doubles after every retry, up to MaxBackoff, 10s if zero.
+		MinBackoff	time.Duration
+		MaxBackoff	time.Duration
+		// Codes are the codes of the GraphQL errors to retry.
+		Codes				[]string
+}
+
+// retries tells whether a request failing with err on the given attempt is
+// retried, and after which delay.
+func (p *RetryPolicy) retries(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+		maxAttempts := p.MaxAttempts
+		if maxAttempts <= 0 {
+				maxAttempts = 3
+		}
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil {
+				return 0, false
+		}
+
+		minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+		if minBackoff <= 0 {
+				minBackoff = 100 * time.Millisecond
+		}
+		if maxBackoff <= 0 {
+				maxBackoff = 10 * time.Second
+		}
+
+		var httpErr *HTTPError
+		var graphqlErrs GraphQLErrors
+		if errors.As(err, &httpErr) {
+				if httpErr.StatusCode != http.StatusTooManyRequests && httpErr.StatusCode < 500 {
+						return 0, false
+				}
+
+				// A longer Retry-After would block the call for as long, unless ctx
+				// has a deadline.
+				if delay, ok := retryAfter(httpErr.Header.Get("Retry-After")); ok {
+						if delay > maxBackoff {
+								delay = maxBackoff
+						}
+						return delay, true
+				}
+		} else if errors.As(err, &graphqlErrs) {
+				if !p.retriesCodes(graphqlErrs) {
+						return 0, false
+				}
+		}
+
+		backoff := maxBackoff
+		if attempt < 32 && minBackoff << (attempt - 1) < maxBackoff {
+				backoff = minBackoff << (attempt - 1)
+		}
+
+		// Half of the backoff is random, so that clients failing together do not
+		// retry together.
+		return backoff / 2 + time.Duration(rand.Int63n(int64(backoff / 2) + 1)), true
+}
+
+func (p *RetryPolicy) retriesCodes(errs GraphQLErrors) bool {
+		for _, err := range errs {
+				for _, code := range p.Codes {
+						if err.HasCode(code) {
+								return true
+						}
+				}
+		}
+
+		return false
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date.
+func retryAfter(header string) (time.Duration, bool) {
+		if header == "" {
+				return 0, false
+		}
+
+		if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+		}
+
+		if date, err := http.ParseTime(header); err == nil {
+				if delay := time.Until(date); delay > 0 {
+						return delay, true
+				}
+				return 0, true
+		}
+
+		return 0, false
+}
+
+type retrySafeContextKey struct{}
+
+// RetrySafe returns a context allowing the RetryPolicy of a client to retry
+// mutations, for the ones that are safe to run twice.
+func RetrySafe(ctx context.Context) context.Context {
+		return context.WithValue(ctx, retrySafeContextKey{}, true)
+}
+
+func isRetrySafe(ctx context.Context) bool {
+		safe, _ := ctx.Value(retrySafeContextKey{}).(bool)
+		return safe
+}
+
+// isMutation tells whether query has a mutation operation. Every top level
+// name is looked at, past fragments and comments, so that documents it cannot
+// tell apart, such as one with a fragment named mutation, are not retried.
+func isMutation(query string) bool {
+		depth := 0
+		for i := 0; i < len(query); {
+				switch c := query[i]; {
+				case c == '#':
+						for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+								i++
+						}
+				case strings.HasPrefix(query[i:], `"""`):
+						for i += 3; i < len(query) && !strings.HasPrefix(query[i:], `"""`); i++ {
+								if strings.HasPrefix(query[i:], `\"""`) {
+										i += 3
+								}
+						}
+						i += 3
+				case c == '"':
+						for i++; i < len(query) && query[i] != '"'; i++ {
+								if query[i] == '\\' {
+										i++
+								}
+						}
+						i++
+				case c == '{' || c == '(' || c == '[':
+						depth++
+						i++
+				case c == '}' || c == ')' || c == ']':
+						depth--
+						i++
+				case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+						start := i
+						for i < len(query) && (query[i] == '_' || (query[i] >= 'a' && query[i] <= 'z') || (query[i] >= 'A' && query[i] <= 'Z') || (query[i] >= '0' && query[i] <= '9')) {
+								i++
+						}
+
+						if depth == 0 && query[start:i] == "mutation" {
+								return true
+						}
+				default:
+						i++
+				}
+		}
+
+		return false
+}
+
+// maxErrorBody is the number of bytes of the body kept by an HTTPError.
+const maxErrorBody = 1024
+
+// HTTPError is returned for responses that do not hold a GraphQL result, such
+// as the error page of a gateway. Err tells what was wrong with a successful
+// response; for other statuses, it holds the GraphQLErrors of the body if any.
+type HTTPError struct {
+		StatusCode	int
+		Status			string
+		Header			http.Header
+		// Body is truncated to its first kilobyte.
+		Body				string
+		Err					error
+}
+
+func newHTTPError(response *http.Response, body []byte, err error) *HTTPError {
+		if len(body) > maxErrorBody {
+				body = body[:maxErrorBody]
+		}
+
+		return &HTTPError{
+				StatusCode: response.StatusCode,
+				Status: response.Status,
+				Header: response.Header,
+				Body: string(body),
+				Err: err,
+		}
+}
+
+func (e *HTTPError) Error() string {
+		message := "unexpected response " + e.Status
+		if e.Err != nil {
+				message += ": " + e.Err.Error()
+		}
+		if e.Body != "" {
+				message += "\n" + e.Body
+		}
+
+		return message
+}
+
+func (e *HTTPError) Unwrap() error {
+		return e.Err
+}
+
+// readResponse reads the body of response, which is an HTTPError unless its
+// status is a 2xx.
+func readResponse(response *http.Response) ([]byte, error) {
+		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
 				return nil, err
 		}
 
-		if result.Errors == nil {
-				return &result, nil
-		} else if len(result.Errors) > 0 {
-				return nil, result.Errors
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+				var result GraphQLResult
+				if json.Unmarshal(body, &result) == nil && len(result.Errors) > 0 {
+						return nil, newHTTPError(response, body, result.Errors)
+				}
+
+				return nil, newHTTPError(response, body, nil)
+		}
+
+		return body, nil
+}
+
+// decodes tells whether the generated methods decode the data of response,
+// given the error returned by Request. Partial data is only decoded when
+// PartialData is set.
+func (c *AdminClient) decodes(response *GraphQLResult, err error) bool {
+		if err == nil {
+				return true
 		}
 
-		return nil, errors.New("Unknown error occured")
+		var errs GraphQLErrors
+		return c.PartialData && errors.As(err, &errs) && response != nil &&
+				len(response.Data) > 0 && !bytes.Equal(response.Data, []byte("null"))
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+		ctx := context.Background()
+		unavailable := &HTTPError{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		retryAfter := func(value string) error {
+				return &HTTPError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {value}}}
+		}
+
+		tests := []struct {
+				name		string
+				policy	RetryPolicy
+				attempt	int
+				err			error
+				retry		bool
+				// delay is checked when set, as backoffs are random.
+				delay		time.Duration
+		}{
+				{"default attempts", RetryPolicy{}, 2, unavailable, true, 0},
+				{"default attempts exhausted", RetryPolicy{}, 3, unavailable, false, 0},
+				{"retries disabled", RetryPolicy{MaxAttempts: 1}, 1, unavailable, false, 0},
+				{"client error", RetryPolicy{}, 1, &HTTPError{StatusCode: http.StatusBadRequest}, false, 0},
+				{"network error", RetryPolicy{}, 1, errors.New("connection reset"), true, 0},
+				{"retry after", RetryPolicy{}, 1, retryAfter("2"), true, 2 * time.Second},
+				{"retry after capped", RetryPolicy{}, 1, retryAfter("3600"), true, 10 * time.Second},
+				{"retry after capped by max backoff", RetryPolicy{MaxBackoff: time.Second}, 1, retryAfter("3600"), true, time.Second},
+				{"code", RetryPolicy{Codes: []string{"busy"}}, 1, GraphQLErrors{{Extensions: map[string]interface{}{"code": "busy"}}}, true, 0},
+				{"other code", RetryPolicy{Codes: []string{"busy"}}, 1, GraphQLErrors{{Extensions: map[string]interface{}{"code": "invalid"}}}, false, 0},
+		}
+
+		for _, test := range tests {
+				delay, retry := test.policy.retries(ctx, test.attempt, test.err)
+				if retry != test.retry {
+						t.Errorf("%s: retry = %v, want %v", test.name, retry, test.retry)
+				}
+				if test.delay != 0 && delay != test.delay {
+						t.Errorf("%s: delay = %v, want %v", test.name, delay, test.delay)
+				}
+				if delay > 10 * time.Second {
+						t.Errorf("%s: delay %v exceeds the default MaxBackoff", test.name, delay)
+				}
+		}
+}
+
+func TestIsMutation(t *testing.T) {
+		tests := []struct {
+				query			string
+				mutation	bool
+		}{
+				{`query Users { users { id } }`, false},
+				{`{ users { id } }`, false},
+				{`mutation Insert { insert_users { affected_rows } }`, true},
+				{`  # inserts a user
+						mutation($name: String) { insert_users(name: $name) { affected_rows } }`, true},
+				{`fragment Parts on users { id } mutation Insert { insert_users { returning { ...Parts } } }`, true},
+				{`query Mutations { mutation: last_mutation { id } }`, false},
+				{`# mutation
+						query Users { users(where: {name: {_eq: "mutation"}}) { id } }`, false},
+				{`query Users($name: String = """ " mutation """) { users(name: $name) { id } }`, false},
+				{`subscription Users { users { id } }`, false},
+		}
+
+		for _, test := range tests {
+				if isMutation(test.query) != test.mutation {
+						t.Errorf("isMutation(%s) = %v, want %v", test.query, !test.mutation, test.mutation)
+				}
+		}
+}
+
+// TestRequestRetries counts the attempts of requests to a server which is
+// always unavailable, to check that mutations are only sent once.
+func TestRequestRetries(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := &AdminClient{
+				Endpoint: server.URL,
+				Retry: &RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		}
+
+		ctx := context.Background()
+		query := `query Users { users { id } }`
+		mutation := `fragment Parts on users { id } mutation Insert { insert_users { returning { ...Parts } } }`
+
+		tests := []struct {
+				name			string
+				request		func() error
+				attempts	int
+		}{
+				{"query", func() error { _, err := client.Request(ctx, query, nil); return err }, 3},
+				{"mutation", func() error { _, err := client.Request(ctx, mutation, nil); return err }, 1},
+				{"retry safe mutation", func() error { _, err := client.Request(RetrySafe(ctx), mutation, nil); return err }, 3},
+				{"generated mutation", func() error { _, err := client.request(ctx, "mutation", query, nil); return err }, 1},
+				{"generated query", func() error { _, err := client.request(ctx, "query", query, nil); return err }, 3},
+		}
+
+		for _, test := range tests {
+				attempts = 0
+
+				var httpErr *HTTPError
+				if err := test.request(); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+						t.Errorf("%s: err = %v, want status %d", test.name, err, http.StatusServiceUnavailable)
+				}
+				if attempts != test.attempts {
+						t.Errorf("%s: %d attempts, want %d", test.name, attempts, test.attempts)
+				}
+		}
+}
+
+func TestGraphQLErrorPathString(t *testing.T) {
+		tests := []struct {
+				path	string
+				want	string
+		}{
+				{`null`, ""},
+				{`["users"]`, "users"},
+				{`["users", 0, "posts", 12, "title"]`, "users[0].posts[12].title"},
+				{`["users", 1000000, "name"]`, "users[1000000].name"},
+				{`["matrix", 1, 2]`, "matrix[1][2]"},
+		}
+
+		for _, test := range tests {
+				var err GraphQLError
+				if decodeErr := json.Unmarshal([]byte(`{"message": "failed", "path": ` + test.path + `}`), &err); decodeErr != nil {
+						t.Fatal(decodeErr)
+				}
+
+				if path := err.PathString(); path != test.want {
+						t.Errorf("PathString() of %s = %s, want %s", test.path, path, test.want)
+				}
+		}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+		var errs GraphQLErrors
+		if err := json.Unmarshal([]byte(`[
+				{"message": "not found", "path": ["user"]},
+				{"message": "duplicate", "path": ["insert_users", 1], "extensions": {"code": "constraint-violation"}}
+		]`), &errs); err != nil {
+				t.Fatal(err)
+		}
+
+		if errs.Error() != "user: not found\ninsert_users[1]: duplicate (constraint-violation)" {
+				t.Errorf("Error() = %q", errs.Error())
+		}
+
+		// Errors are usually wrapped along the way.
+		var err error = fmt.Errorf("inserting users: %w", errs)
+
+		if !errors.Is(err, ErrorCode("constraint-violation")) {
+				t.Errorf("errors.Is does not match the code of the second error")
+		}
+		if errors.Is(err, ErrorCode("permission-error")) {
+				t.Errorf("errors.Is matches a code none of the errors has")
+		}
+
+		var first GraphQLError
+		if !errors.As(err, &first) || first.Message != "not found" {
+				t.Errorf("errors.As gets %+v, want the first error", first)
+		}
+
+		var all GraphQLErrors
+		if !errors.As(err, &all) || len(all) != 2 {
+				t.Errorf("errors.As gets %d GraphQLErrors, want 2", len(all))
+		}
+
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+				t.Errorf("errors.As gets an HTTPError out of GraphQLErrors")
+		}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFiles are looked up in the working directory when no -config flag is
+// given. JSON is a subset of YAML, so both are read by the same decoder.
+var configFiles = []string{
+		"graphql-codegen.yml",
+		"graphql-codegen.yaml",
+		"graphql-codegen.json",
+}
+
+// stringList accepts either a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+		var single string
+		if err := unmarshal(&single); err == nil {
+				*l = stringList{single}
+				return nil
+		}
+
+		var list []string
+		if err := unmarshal(&list); err != nil {
+				return err
+		}
+
+		*l = stringList(list)
+		return nil
+}
+
+// Project describes a single generated client.
+type Project struct {
+		Name			string				`yaml:"-"`
+		Endpoint		string				`yaml:"endpoint"`
+		Schema			stringList			`yaml:"schema"`
+		Operations		stringList			`yaml:"operations"`
+		Package			string				`yaml:"package"`
+		Output			string				`yaml:"output"`
+		Split			string				`yaml:"split"`
+		Headers			map[string]string	`yaml:"headers"`
+		Scalars			map[string]string	`yaml:"scalars"`
+		UnmappedScalars	string				`yaml:"unmappedScalars"`
+		OptionalInputs	string				`yaml:"optionalInputs"`
+		UnknownEnumValues	string			`yaml:"unknownEnumValues"`
+		Initialisms		stringList			`yaml:"initialisms"`
+		Names			map[string]string	`yaml:"names"`
+		Full			*bool				`yaml:"full"`
+}
+
+// Config is the content of a graphql-codegen.yml file. The top level settings
+// describe a single project, or act as defaults for every entry of Projects.
+type Config struct {
+		Project									`yaml:",inline"`
+		Projects map[string]*Project	`yaml:"projects"`
+}
+
+// inherit fills every setting p leaves unset from defaults.
+func (p *Project) inherit(defaults *Project) {
+		if p.Endpoint == "" {
+				p.Endpoint = defaults.Endpoint
+		}
+		if p.Schema == nil {
+				p.Schema = defaults.Schema
+		}
+		if p.Operations == nil {
+				p.Operations = defaults.Operations
+		}
+		if p.Package == "" {
+				p.Package = defaults.Package
+		}
+		if p.Output == "" {
+				p.Output = defaults.Output
+		}
+		if p.Split == "" {
+				p.Split = defaults.Split
+		}
+		if p.UnmappedScalars == "" {
+				p.UnmappedScalars = defaults.UnmappedScalars
+		}
+		if p.OptionalInputs == "" {
+				p.OptionalInputs = defaults.OptionalInputs
+		}
+		if p.UnknownEnumValues == "" {
+				p.UnknownEnumValues = defaults.UnknownEnumValues
+		}
+		if p.Initialisms == nil {
+				p.Initialisms = defaults.Initialisms
+		}
+		if p.Full == nil {
+				p.Full = defaults.Full
+		}
+
+		p.Headers = mergeMaps(defaults.Headers, p.Headers)
+		p.Scalars = mergeMaps(defaults.Scalars, p.Scalars)
+		p.Names = mergeMaps(defaults.Names, p.Names)
+}
+
+// resolvePaths makes the file paths of p relative to dir, the directory of
+// the config file that declared them.
+func (p *Project) resolvePaths(dir string) {
+		resolve := func(path string) string {
+				if path == "" || filepath.IsAbs(path) {
+						return path
+				}
+				return filepath.Join(dir, path)
+		}
+
+		// Projects may share their lists with the defaults, so new ones are built.
+		resolveAll := func(paths stringList) stringList {
+				if paths == nil {
+						return nil
+				}
+
+				resolved := stringList{}
+				for _, path := range paths {
+						resolved = append(resolved, resolve(path))
+				}
+				return resolved
+		}
+
+		p.Schema = resolveAll(p.Schema)
+		p.Operations = resolveAll(p.Operations)
+		p.Output = resolve(p.Output)
+}
+
+func mergeMaps(base map[string]string, override map[string]string) map[string]string {
+		merged := make(map[string]string)
+		for k, v := range base {
+				merged[k] = v
+		}
+		for k, v := range override {
+				merged[k] = v
+		}
+
+		return merged
+}
+
+// findConfig returns the path of the config file to use, or an empty string
+// if there is none.
+func findConfig(path string) (string, error) {
+		if path != "" {
+				return path, nil
+		}
+
+		for _, file := range configFiles {
+				if _, err := os.Stat(file); err == nil {
+						return file, nil
+				} else if !os.IsNotExist(err) {
+						return "", err
+				}
+		}
+
+		return "", nil
+}
+
+// LoadConfig reads the config file at path and returns its projects sorted by
+// name. Header values may reference environment variables as $VAR or ${VAR}.
+func LoadConfig(path string) ([]*Project, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+				return nil, err
+		}
+
+		var config Config
+		if err = yaml.UnmarshalStrict(content, &config); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		projects := []*Project{}
+		if len(config.Projects) == 0 {
+				project := config.Project
+				projects = append(projects, &project)
+		} else {
+				names := make([]string, 0, len(config.Projects))
+				for name := range config.Projects {
+						names = append(names, name)
+				}
+				sort.Strings(names)
+
+				for _, name := range names {
+						project := config.Projects[name]
+						if project == nil {
+								project = &Project{}
+						}
+
+						project.Name = name
+						project.inherit(&config.Project)
+						projects = append(projects, project)
+				}
+		}
+
+		dir := filepath.Dir(path)
+		for _, project := range projects {
+				project.resolvePaths(dir)
+
+				for k, v := range project.Headers {
+						project.Headers[k] = os.ExpandEnv(v)
+				}
+		}
+
+		return projects, nil
+}
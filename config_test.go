@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestConfig writes content to a config file named name in a new
+// directory, and returns its path.
+func writeTestConfig(t *testing.T, name string, content string) string {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+		}
+
+		return path
+}
+
+func TestLoadConfig(t *testing.T) {
+		os.Setenv("TEST_CONFIG_TOKEN", "secret")
+		defer os.Unsetenv("TEST_CONFIG_TOKEN")
+
+		full := true
+
+		tests := []struct {
+				name			string
+				file			string
+				content		string
+				// projects have paths relative to the directory of the config.
+				projects	[]Project
+				err				string
+		}{
+				{
+						name: "single project",
+						file: "graphql-codegen.yml",
+						content: `
+schema: schema.graphql
+operations: [ops/*.graphql]
+package: client
+output: /abs/client.go
+headers:
+  Authorization: Bearer ${TEST_CONFIG_TOKEN}
+  X-Hasura-Role: $TEST_CONFIG_UNSET
+full: true
+`,
+						projects: []Project{{
+								Schema: stringList{"schema.graphql"},
+								Operations: stringList{"ops/*.graphql"},
+								Package: "client",
+								Output: "/abs/client.go",
+								Headers: map[string]string{"Authorization": "Bearer secret", "X-Hasura-Role": ""},
+								Full: &full,
+						}},
+				},
+				{
+						name: "projects inherit the defaults",
+						file: "graphql-codegen.yml",
+						content: `
+endpoint: https://example.com/v1/graphql
+package: client
+headers: {X-Hasura-Admin-Secret: $TEST_CONFIG_TOKEN}
+scalars: {uuid: string, bigint: int64}
+names: {users: User}
+full: true
+projects:
+  users:
+    operations: users/*.graphql
+    output: users
+    split: kind
+    headers: {X-Hasura-Role: user}
+    scalars: {uuid: github.com/google/uuid.UUID}
+    full: false
+  admin:
+    package: admin
+    output: admin.go
+    names: {GetUsers: ListUsers}
+`,
+						projects: []Project{
+								{
+										Name: "admin",
+										Endpoint: "https://example.com/v1/graphql",
+										Package: "admin",
+										Output: "admin.go",
+										Headers: map[string]string{"X-Hasura-Admin-Secret": "secret"},
+										Scalars: map[string]string{"uuid": "string", "bigint": "int64"},
+										Names: map[string]string{"users": "User", "GetUsers": "ListUsers"},
+										Full: &full,
+								},
+								{
+										Name: "users",
+										Endpoint: "https://example.com/v1/graphql",
+										Operations: stringList{"users/*.graphql"},
+										Package: "client",
+										Output: "users",
+										Split: SPLIT_KIND,
+										Headers: map[string]string{"X-Hasura-Admin-Secret": "secret", "X-Hasura-Role": "user"},
+										Scalars: map[string]string{"uuid": "github.com/google/uuid.UUID", "bigint": "int64"},
+										Names: map[string]string{"users": "User"},
+										Full: new(bool),
+								},
+						},
+				},
+				{
+						name: "json",
+						file: "graphql-codegen.json",
+						content: `{"schema": ["a.graphql", "b.graphql"], "package": "client"}`,
+						projects: []Project{{
+								Schema: stringList{"a.graphql", "b.graphql"},
+								Package: "client",
+						}},
+				},
+				{
+						name: "unknown setting",
+						file: "graphql-codegen.yml",
+						content: "pakage: client\n",
+						err: "field pakage not found",
+				},
+		}
+
+		for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+						path := writeTestConfig(t, test.file, test.content)
+
+						projects, err := LoadConfig(path)
+						if test.err != "" {
+								if err == nil || !strings.Contains(err.Error(), test.err) {
+										t.Fatalf("err = %v, want %s", err, test.err)
+								}
+								return
+						} else if err != nil {
+								t.Fatal(err)
+						}
+
+						if len(projects) != len(test.projects) {
+								t.Fatalf("%d projects, want %d", len(projects), len(test.projects))
+						}
+
+						for i, project := range projects {
+								want := test.projects[i]
+								want.resolvePaths(filepath.Dir(path))
+
+								if !reflect.DeepEqual(*project, want) {
+										t.Errorf("project %d = %+v, want %+v", i, *project, want)
+								}
+						}
+				})
+		}
+}
+
+func TestLoadProjectsFlags(t *testing.T) {
+		path := writeTestConfig(t, "graphql-codegen.yml", `
+package: client
+headers: {X-Hasura-Role: user}
+projects:
+  a: {output: a.go}
+  b: {output: b.go, full: true}
+`)
+
+		for name, value := range map[string]string{"config": path, "package": "flags", "full": "false"} {
+				if err := flag.Set(name, value); err != nil {
+						t.Fatal(err)
+				}
+		}
+		headerList = headers{"X-Hasura-Role: admin"}
+		scalarList = headers{"uuid = string"}
+		defer func() {
+				*configPath, *packageName, *fullSchema = "", "main", false
+				headerList, scalarList = nil, nil
+		}()
+
+		projects, err := loadProjects()
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		for _, project := range projects {
+				if project.Package != "flags" || project.Full == nil || *project.Full {
+						t.Errorf("%s: the -package and -full flags were not applied", project.Name)
+				}
+				if project.Headers["X-Hasura-Role"] != "admin" || project.Scalars["uuid"] != "string" {
+						t.Errorf("%s: the -H and -scalar flags were not applied", project.Name)
+				}
+				if filepath.Base(project.Output) != project.Name + ".go" {
+						t.Errorf("%s: output = %s, want the one of its config", project.Name, project.Output)
+				}
+		}
+
+		scalarList = headers{"uuid"}
+		if _, err = loadProjects(); err == nil || !strings.Contains(err.Error(), "expected name=type") {
+				t.Errorf("err = %v, want an invalid scalar mapping", err)
+		}
+}
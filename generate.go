@@ -1,18 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
-	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+	_ "github.com/vektah/gqlparser/v2/validator/rules"
 
 	_ "embed"
 )
 
-var typeMap = map[string]string{
+var defaultTypeMap = map[string]string{
 		"ID":						"string",
 		"Int":					"int64",
 		"uuid":					"string",
@@ -24,6 +31,208 @@ var typeMap = map[string]string{
 		"URL":					"string",
 }
 
+// typeMap maps the scalars of the project being generated to Go types.
+var typeMap = defaultTypeMap
+
+// configureScalars sets up typeMap with the default mappings, overridden by
+// the scalar mappings of a project. A mapping may name a type of another
+// package by its import path, as in github.com/google/uuid.UUID, optionally
+// prefixed by [] or *.
+func configureScalars(scalars map[string]string) error {
+		typeMap = make(map[string]string)
+		for name, goType := range defaultTypeMap {
+				typeMap[name] = goType
+		}
+
+		knownImports = make(map[string]string)
+		for name, path := range defaultImports {
+				knownImports[name] = path
+		}
+
+		for name, goType := range scalars {
+				qualified, err := qualifyType(goType)
+				if err != nil {
+						return fmt.Errorf("scalar %s: %v", name, err)
+				}
+
+				typeMap[name] = qualified
+		}
+
+		return nil
+}
+
+// qualifyType turns a mapping such as []github.com/google/uuid.UUID into the
+// Go type generated code refers to, []uuid.UUID, and registers the import it
+// requires.
+func qualifyType(goType string) (string, error) {
+		goType = strings.TrimSpace(goType)
+		base := strings.TrimLeft(goType, "[]*")
+		prefix := goType[:len(goType)-len(base)]
+
+		dot := strings.LastIndex(base, ".")
+		if dot < 0 {
+				return goType, nil
+		}
+
+		path, name := base[:dot], base[dot+1:]
+		if path == "" || name == "" {
+				return "", fmt.Errorf("invalid type %s", goType)
+		}
+
+		if !strings.Contains(path, "/") {
+				// A bare package name refers to a default import, such as json, or to
+				// the standard library package at that path, such as time.
+				if knownPath, ok := defaultImports[path]; ok {
+						path = knownPath
+				} else if !standardPackages[path] {
+						return "", fmt.Errorf("unknown package %s in %s, give its import path, as in math/big.Int", path, goType)
+				}
+		}
+
+		pkg := importName(path)
+		if existing, ok := knownImports[pkg]; ok && existing != path {
+				return "", fmt.Errorf("package name %s of %s is already used by %s", pkg, path, existing)
+		}
+		knownImports[pkg] = path
+
+		return prefix + pkg + "." + name, nil
+}
+
+// standardPackages are the standard library packages whose import path is a
+// single element.
+var standardPackages = map[string]bool{
+		"bufio": true, "bytes": true, "context": true, "crypto": true, "embed": true,
+		"encoding": true, "errors": true, "expvar": true, "flag": true, "fmt": true,
+		"hash": true, "html": true, "image": true, "io": true, "log": true, "math": true,
+		"mime": true, "net": true, "os": true, "path": true, "plugin": true,
+		"reflect": true, "regexp": true, "runtime": true, "sort": true, "strconv": true,
+		"strings": true, "sync": true, "syscall": true, "testing": true, "time": true,
+		"unicode": true, "unsafe": true,
+}
+
+// importName guesses the name of the package at an import path from its
+// last element, skipping major version suffixes.
+func importName(path string) string {
+		elems := strings.Split(path, "/")
+		name := elems[len(elems)-1]
+
+		if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+				name = elems[len(elems)-2]
+		}
+
+		// gopkg.in/yaml.v2
+		if i := strings.Index(name, "."); i > 0 {
+				name = name[:i]
+		}
+
+		name = strings.TrimPrefix(name, "go-")
+		return strings.Map(func(r rune) rune {
+				if r == '-' || r == '.' {
+						return -1
+				}
+				return r
+		}, name)
+}
+
+const (
+		UNMAPPED_STRING		= "string"
+		UNMAPPED_RAW			= "raw"
+		UNMAPPED_STRICT		= "strict"
+)
+
+// resolveScalars decides the Go type of every scalar of the schema missing
+// from typeMap, according to mode: string (the default) warns about them,
+// raw decodes them as json.RawMessage and strict fails listing the fields
+// that use them. Only fields that end up in the generated code are reported,
+// but strict also fails on the scalars no such field uses, which would still
+// be declared as string.
+func resolveScalars(schema *ast.Schema, queryDoc *ast.QueryDocument, full bool, mode string) error {
+		fallback := "string"
+		switch mode {
+		case "", UNMAPPED_STRING, UNMAPPED_STRICT:
+		case UNMAPPED_RAW:
+				fallback = "json.RawMessage"
+		default:
+				return fmt.Errorf("unknown unmapped scalar mode %s", mode)
+		}
+
+		unmapped := []string{}
+		for name, def := range schema.Types {
+				if def.Kind == ast.Scalar {
+						if _, ok := typeMap[name]; !ok {
+								unmapped = append(unmapped, name)
+						}
+				}
+		}
+		sort.Strings(unmapped)
+
+		usages := make(map[string][]string)
+		use := func(t *ast.Type, usage string) {
+				if _, ok := typeMap[t.Name()]; !ok {
+						usages[t.Name()] = append(usages[t.Name()], usage)
+				}
+		}
+
+		for _, def := range schema.Types {
+				if def.Kind == ast.InputObject || (full && (def.Kind == ast.Object || def.Kind == ast.Interface)) {
+						for _, field := range def.Fields {
+								use(field.Type, def.Name + "." + field.Name)
+						}
+				}
+		}
+
+		var useSelectionSet func(selectionSet ast.SelectionSet, path string)
+		useSelectionSet = func(selectionSet ast.SelectionSet, path string) {
+				for _, selection := range selectionSet {
+						switch selection := selection.(type) {
+						case *ast.Field:
+								if len(selection.SelectionSet) == 0 && selection.Definition != nil {
+										use(selection.Definition.Type, path + "." + selection.Alias)
+								}
+								useSelectionSet(selection.SelectionSet, path + "." + selection.Alias)
+						case *ast.FragmentSpread:
+								useSelectionSet(selection.Definition.SelectionSet, path)
+						case *ast.InlineFragment:
+								useSelectionSet(selection.SelectionSet, path)
+						}
+				}
+		}
+
+		for _, op := range queryDoc.Operations {
+				for _, variable := range op.VariableDefinitions {
+						use(variable.Type, op.Name + ".$" + variable.Variable)
+				}
+				useSelectionSet(op.SelectionSet, op.Name)
+		}
+		for _, fragment := range queryDoc.Fragments {
+				useSelectionSet(fragment.SelectionSet, fragment.Name)
+		}
+
+		var sb strings.Builder
+		for _, name := range unmapped {
+				if len(usages[name]) > 0 {
+						sort.Strings(usages[name])
+						sb.WriteString(fmt.Sprintf("\n  %s: used by %s", name, strings.Join(usages[name], ", ")))
+				} else if mode == UNMAPPED_STRICT {
+						sb.WriteString(fmt.Sprintf("\n  %s: not used by the generated code", name))
+				}
+		}
+
+		if sb.Len() > 0 {
+				if mode == UNMAPPED_STRICT {
+						return fmt.Errorf("scalars without a Go type mapping:%s", sb.String())
+				} else if mode != UNMAPPED_RAW {
+						fmt.Fprintf(os.Stderr, "Warning: scalars without a Go type mapping are generated as string:%s\n", sb.String())
+				}
+		}
+
+		for _, name := range unmapped {
+				typeMap[name] = fallback
+		}
+
+		return nil
+}
+
 //go:embed templates/schema.gotpl
 var schemaTmpl string
 
@@ -33,14 +242,244 @@ var inputsTmpl string
 //go:embed templates/operations.gotpl
 var operationsTmpl string
 
-func generateInputs(schema *ast.Schema, out io.Writer) error {
+const (
+		OPTIONAL_POINTER	= "pointer"
+		OPTIONAL_GENERIC	= "generic"
+		OPTIONAL_TYPED		= "typed"
+)
+
+// optionalType describes the declaration of an Optional wrapper. Generic and
+// typed wrappers share their template, so every name is given in full.
+type optionalType struct {
+		Decl	string
+		Type	string
+		Value	string
+		Make	string
+		Null	string
+}
+
+// optionalFormatter formats the nullable input fields according to mode, and
+// collects the typed Optional wrappers they use.
+type optionalFormatter struct {
+		mode	string
+		typed	map[string]optionalType
+}
+
+func (f *optionalFormatter) isOptional(t *ast.Type) bool {
+		return f.mode != OPTIONAL_POINTER && !t.NonNull
+}
+
+func (f *optionalFormatter) formatFieldType(t *ast.Type) string {
+		if !f.isOptional(t) {
+				return formatType(t)
+		}
+
+		nonNull := *t
+		nonNull.NonNull = true
+		value := formatType(&nonNull)
+
+		if f.mode == OPTIONAL_GENERIC {
+				return "Optional[" + value + "]"
+		}
+
+		name := "Optional" + optionalTypeName(t)
+		f.typed[name] = optionalType{
+				Decl: name,
+				Type: name,
+				Value: value,
+				Make: "Make" + name,
+				Null: "Null" + name,
+		}
+
+		return name
+}
+
+// types returns the Optional wrappers to declare.
+func (f *optionalFormatter) types() []optionalType {
+		if f.mode == OPTIONAL_GENERIC {
+				return []optionalType{{
+						Decl: "Optional[T any]",
+						Type: "Optional[T]",
+						Value: "T",
+						Make: "MakeOptional[T any]",
+						Null: "NullOptional[T any]",
+				}}
+		}
+
+		names := make([]string, 0, len(f.typed))
+		for name := range f.typed {
+				names = append(names, name)
+		}
+		sort.Strings(names)
+
+		types := []optionalType{}
+		for _, name := range names {
+				types = append(types, f.typed[name])
+		}
+
+		return types
+}
+
+// optionalTypeName names the typed Optional wrapper of t after its GraphQL
+// type, such as NullableStringList for [String].
+func optionalTypeName(t *ast.Type) string {
+		if t.Elem == nil {
+				return typeName(t.Name())
+		}
+
+		name := optionalTypeName(t.Elem) + "List"
+		if !t.Elem.NonNull {
+				name = "Nullable" + name
+		}
+
+		return name
+}
+
+// validationFormatter generates the checks of the Validate methods of inputs
+// and variables structs.
+type validationFormatter struct {
+		schema *ast.Schema
+}
+
+// formatValidation returns the statements checking expr, the Go value of a
+// field of type t, which return a ValidationError at path when it fails. When
+// optional is set, a nullable value is an Optional wrapper.
+func (f *validationFormatter) formatValidation(expr string, path string, t *ast.Type, optional bool) string {
+		return f.validation(expr, path, nil, t, optional, false, 0)
+}
+
+// validation is formatValidation for the value at the path given by format
+// and the Go expressions of its list indices. A nil expr is only invalid when
+// it is not the dereferenced value of a nullable type.
+func (f *validationFormatter) validation(expr string, format string, indices []string, t *ast.Type, optional bool, dereferenced bool, depth int) string {
+		path := strconv.Quote(format)
+		if len(indices) > 0 {
+				path = "fmt.Sprintf(" + path + ", " + strings.Join(indices, ", ") + ")"
+		}
+
+		value := "v" + strconv.Itoa(depth)
+
+		if !t.NonNull {
+				nonNull := *t
+				nonNull.NonNull = true
+
+				inner := f.validation(value, format, indices, &nonNull, false, true, depth + 1)
+				if inner == "" {
+						return ""
+				} else if optional {
+						return "if " + value + ", ok := " + expr + ".Get(); ok {\n" + inner + "}\n"
+				}
+				return "if " + expr + " != nil {\n" + value + " := *" + expr + "\n" + inner + "}\n"
+		}
+
+		if t.Elem != nil {
+				index := "i" + strconv.Itoa(depth)
+
+				code := ""
+				if !dereferenced {
+						code = "if " + expr + " == nil {\nreturn &ValidationError{Path: " + path + ", Message: \"must not be null\"}\n}\n"
+				}
+
+				inner := f.validation(value, format + "[%d]", append(append([]string{}, indices...), index), t.Elem, false, false, depth + 1)
+				if inner != "" {
+						code += "for " + index + ", " + value + " := range " + expr + " {\n" + inner + "}\n"
+				}
+				return code
+		}
+
+		def := f.schema.Types[t.Name()]
+		if def == nil {
+				return ""
+		}
+
+		switch def.Kind {
+		case ast.Enum:
+				return "if !" + expr + ".IsValid() {\nreturn &ValidationError{Path: " + path + ", Message: fmt.Sprintf(\"unknown " + def.Name + " value %q\", " + expr + ")}\n}\n"
+		case ast.InputObject:
+				return "if err := " + expr + ".Validate(); err != nil {\nreturn validationErrorAt(" + path + ", err)\n}\n"
+		case ast.Scalar:
+				if !dereferenced && isNilable(formatNamedType(def.Name)) {
+						return "if " + expr + " == nil {\nreturn &ValidationError{Path: " + path + ", Message: \"must not be null\"}\n}\n"
+				}
+		}
+
+		return ""
+}
+
+// isNilable tells whether the Go type a scalar maps to can be nil, which is
+// sent as null.
+func isNilable(goType string) bool {
+		for _, prefix := range []string{"*", "[]", "map[", "chan ", "func("} {
+				if strings.HasPrefix(goType, prefix) {
+						return true
+				}
+		}
+
+		return goType == "json.RawMessage" || goType == "interface{}" || goType == "any"
+}
+
+// formatVariableValidation returns the checks of the Validate method of the
+// variables struct of an operation for variable.
+func (f *validationFormatter) formatVariableValidation(expr string, variable *ast.VariableDefinition) string {
+		t := variable.Type
+		if t.NonNull && variable.DefaultValue != nil {
+				nullable := *t
+				nullable.NonNull = false
+				t = &nullable
+		}
+
+		return f.formatValidation(expr, "$" + variable.Variable, t, false)
+}
+
+const (
+		ENUM_REJECT		= "reject"
+		ENUM_KEEP			= "keep"
+)
+
+// generateInputs generates the input objects, enums and scalars of schema.
+// Nullable input fields are generated according to optional: pointers omitted
+// when nil, or Optional wrappers which can also be sent as null. Decoding an
+// unknown enum value fails, unless unknownEnums is keep.
+func generateInputs(schema *ast.Schema, optional string, unknownEnums string, out io.Writer) error {
 		fmt.Println("Generating input types...")
 
+		switch unknownEnums {
+		case "", ENUM_REJECT, ENUM_KEEP:
+		default:
+				return fmt.Errorf("unknown enum values mode %s", unknownEnums)
+		}
+
+		switch optional {
+		case "":
+				optional = OPTIONAL_POINTER
+		case OPTIONAL_POINTER, OPTIONAL_GENERIC, OPTIONAL_TYPED:
+		default:
+				return fmt.Errorf("unknown optional inputs mode %s", optional)
+		}
+
+		optionals := &optionalFormatter{mode: optional, typed: make(map[string]optionalType)}
+		validations := &validationFormatter{schema: schema}
+
 		tmpl, err := template.New("inputs.gotpl").Funcs(template.FuncMap{
-				"formatName": formatName,
+				"formatName": typeName,
+				"formatDoc": formatDoc,
 				"formatScalar": formatScalar,
 				"formatType": formatType,
+				"fieldNames": definitionFieldNames,
+				"enumValueName": enumValueName,
+				"formatFieldType": optionals.formatFieldType,
+				"isOptional": optionals.isOptional,
+				"usesOptional": func() bool { return optional != OPTIONAL_POINTER },
+				"isImportedType": isImportedType,
+				"keepsUnknownEnums": func() bool { return unknownEnums == ENUM_KEEP },
+				"optionalTypes": optionals.types,
+				"formatValidation": func(expr string, path string, t *ast.Type) string {
+						return validations.formatValidation(expr, path, t, optionals.isOptional(t))
+				},
 		}).Parse(inputsTmpl)
+		if err != nil {
+				return err
+		}
 
 		err = tmpl.Execute(out, schema)
 		if err != nil {
@@ -54,10 +493,15 @@ func generateSchema(schema *ast.Schema, out io.Writer) error {
 		fmt.Println("Generating schema types...")
 
 		tmpl, err := template.New("schema.gotpl").Funcs(template.FuncMap{
-				"formatName": formatName,
+				"formatName": typeName,
+				"formatDoc": formatDoc,
 				"formatScalar": formatScalar,
 				"formatType": formatType,
+				"fieldNames": definitionFieldNames,
 		}).Parse(schemaTmpl)
+		if err != nil {
+				return err
+		}
 
 		err = tmpl.Execute(out, schema)
 		if err != nil {
@@ -70,96 +514,144 @@ func generateSchema(schema *ast.Schema, out io.Writer) error {
 func generateOperations(schema *ast.Schema, queryDoc *ast.QueryDocument, out io.Writer) error {
 		fmt.Println("Generating operations...")
 
+		selections := &selectionFormatter{schema: schema}
+		validations := &validationFormatter{schema: schema}
+
 		tmpl, err := template.New("operations.gotpl").Funcs(template.FuncMap{
-				"formatName": formatName,
+				"formatName": typeName,
+				"formatDoc": formatDoc,
 				"formatScalar": formatScalar,
 				"formatType": formatType,
 				"formatFragmentName": formatFragmentName,
-				"formatSelectionSet": formatSelectionSet,
+				"formatSelectionSet": selections.formatSelectionSet,
+				"selectionTypes": selections.flush,
 				"formatQuery": formatQuery,
+				"formatSourceComment": formatSourceComment,
+				"operationName": operationName,
+				"variableNames": variableNames,
+				"formatVariableValidation": validations.formatVariableValidation,
+				"formatVariableType": formatVariableType,
+				"isOptionalVariable": isOptionalVariable,
+				"operationType": func(op *ast.OperationDefinition) string {
+						return operationType(schema, op)
+				},
 		}).Parse(operationsTmpl)
+		if err != nil {
+				return err
+		}
 
 		err = tmpl.Execute(out, queryDoc)
 		if err != nil {
+				// Errors about the operations carry their document location, which is
+				// more useful than the template one.
+				var gqlErr *gqlerror.Error
+				if errors.As(err, &gqlErr) {
+						return gqlErr
+				}
 				return err
 		}
 
 		return nil
 }
 
-func parseQueryDocuments(schema *ast.Schema, documents []string) (*ast.QueryDocument, error) {
+func parseQueryDocuments(schema *ast.Schema, documents []*ast.Source) (*ast.QueryDocument, error) {
 		var parentDoc ast.QueryDocument
 		parentDoc.Operations = ast.OperationList{}
 		parentDoc.Fragments = ast.FragmentDefinitionList{}
 
 		for _, document := range documents {
-				queryDoc, err := gqlparser.LoadQuery(schema, document)
+				queryDoc, err := parser.ParseQuery(document)
 				if err != nil {
 						return nil, err
 				}
 
-				operations := []*ast.OperationDefinition{}
+				if errs := validator.Validate(schema, queryDoc); errs != nil {
+						return nil, errs
+				}
+
 				for _, op := range queryDoc.Operations {
-						operations = append(operations, inlineOperationDefinition(queryDoc, op))
+						addTypenames(schema, op.SelectionSet)
+				}
+				for _, fragment := range queryDoc.Fragments {
+						addTypenames(schema, fragment.SelectionSet)
 				}
 
-				parentDoc.Operations = append(parentDoc.Operations, operations...)
+				parentDoc.Operations = append(parentDoc.Operations, queryDoc.Operations...)
 				parentDoc.Fragments = append(parentDoc.Fragments, queryDoc.Fragments...)
 		}
 
 		return &parentDoc, nil
 }
 
-func inlineOperationDefinition(queryDoc *ast.QueryDocument, operation *ast.OperationDefinition) *ast.OperationDefinition {
-		operation.SelectionSet = *inlineSelectionSet(queryDoc, &operation.SelectionSet)
-		return operation
-}
-
-func inlineSelectionSet(queryDoc *ast.QueryDocument, selectionSet *ast.SelectionSet) *ast.SelectionSet {
-		if selectionSet == nil || len(*selectionSet) == 0 {
-				return nil
-		}
-
-		inlined := ast.SelectionSet{}
-		for _, selection := range *selectionSet {
+// addTypenames adds __typename to the polymorphic selections of selectionSet
+// that lack it, so that the result can be decoded into the right Go type.
+func addTypenames(schema *ast.Schema, selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
 				switch selection := selection.(type) {
 				case *ast.Field:
 						if len(selection.SelectionSet) > 0 {
-								selection.SelectionSet = *inlineSelectionSet(queryDoc, &selection.SelectionSet)
-								inlined = append(inlined, selection)
-						} else {
-								inlined = append(inlined, selection)
+								addTypenames(schema, selection.SelectionSet)
+
+								if isPolymorphic(schema, selection) && !hasTypename(selection.SelectionSet) {
+										selection.SelectionSet = append(ast.SelectionSet{typenameField(selection)}, selection.SelectionSet...)
+								}
 						}
-				case *ast.FragmentSpread:
-						inlined = append(inlined, *inlineSelectionSet(queryDoc, &selection.Definition.SelectionSet)...)
 				case *ast.InlineFragment:
-						inlined = append(inlined, *inlineSelectionSet(queryDoc, &selection.SelectionSet)...)
-				default:
-
+						addTypenames(schema, selection.SelectionSet)
 				}
 		}
-
-		return &inlined
 }
 
-func snakeToCamel(s string) string {
-		tokens := strings.Split(s, "_")
+// isPolymorphic tells whether field selects an interface or union with type
+// conditions on some of its possible types, and thus results in a different
+// Go type depending on the __typename.
+func isPolymorphic(schema *ast.Schema, field *ast.Field) bool {
+		def := schema.Types[field.Definition.Type.Name()]
+		if def == nil || !def.IsAbstractType() {
+				return false
+		}
 
-		var sb strings.Builder
+		return narrows(field.SelectionSet, def.Name)
+}
 
-		for _, token := range tokens {
-				sb.WriteString(strings.Title(token))
+// narrows tells whether selectionSet, selected on typeName, has fragments on
+// other types than typeName.
+func narrows(selectionSet ast.SelectionSet, typeName string) bool {
+		for _, selection := range selectionSet {
+				switch selection := selection.(type) {
+				case *ast.FragmentSpread:
+						if selection.Definition.TypeCondition != typeName || narrows(selection.Definition.SelectionSet, typeName) {
+								return true
+						}
+				case *ast.InlineFragment:
+						if (selection.TypeCondition != "" && selection.TypeCondition != typeName) || narrows(selection.SelectionSet, typeName) {
+								return true
+						}
+				}
 		}
-
-		return sb.String()
+		return false
 }
 
-func formatName(name string) string {
-		return snakeToCamel(name)
+func hasTypename(selectionSet ast.SelectionSet) bool {
+		for _, selection := range selectionSet {
+				if field, ok := selection.(*ast.Field); ok && field.Alias == "__typename" {
+						return true
+				}
+		}
+		return false
 }
 
-func formatFragmentName(name string) string {
-		return strings.Title(name) + "Fragment"
+// typenameField is the __typename selection added to polymorphic fields.
+func typenameField(parent *ast.Field) *ast.Field {
+		return &ast.Field{
+				Alias: "__typename",
+				Name: "__typename",
+				Definition: &ast.FieldDefinition{
+						Name: "__typename",
+						Type: ast.NonNullNamedType("String", nil),
+				},
+				Position: parent.Position,
+		}
 }
 
 func formatScalar(scalar string) string {
@@ -172,7 +664,32 @@ func formatScalar(scalar string) string {
 		}
 }
 
+// isImportedType tells whether goType refers to a type of another package.
+// Scalars mapped to one are declared as an alias, so that it keeps its methods.
+func isImportedType(goType string) bool {
+		return strings.Contains(goType, ".")
+}
+
+// formatNamedType returns the Go type of a named GraphQL type.
+func formatNamedType(name string) string {
+		newType, ok := typeMap[name]
+
+		if ok {
+				return newType
+		} else {
+				return typeName(name)
+		}
+}
+
+// formatType returns the Go type of t. Every nullable level, whether the value
+// itself or the elements of a list at any depth, becomes a pointer.
 func formatType(t *ast.Type) string {
+		return formatTypeWith(t, formatNamedType(t.Name()))
+}
+
+// formatTypeWith is formatType with the innermost named type replaced by
+// named, such as the struct generated for a selection set.
+func formatTypeWith(t *ast.Type, named string) string {
 		var sb strings.Builder
 
 		if !t.NonNull {
@@ -180,93 +697,134 @@ func formatType(t *ast.Type) string {
 		}
 
 		if t.Elem != nil {
-				newType, ok := typeMap[t.Elem.NamedType]
-
-				if ok {
-						sb.WriteString("[]" + newType)
-				} else {
-						sb.WriteString("[]" + snakeToCamel(t.Elem.NamedType))
-				}
+				sb.WriteString("[]" + formatTypeWith(t.Elem, named))
 		} else {
-				newType, ok := typeMap[t.NamedType]
-
-				if ok {
-						sb.WriteString(newType)
-				} else {
-						sb.WriteString(snakeToCamel(t.NamedType))
-				}
+				sb.WriteString(named)
 		}
 
 		return sb.String()
 }
 
-func formatSelectionSet(selectionSet ast.SelectionSet, depth int) string {
-		if len(selectionSet) == 0 { return "" }
+// formatDoc returns the Go doc comment of a schema element, made of its
+// description and the reason of its @deprecated directive, if any.
+func formatDoc(description string, directives ast.DirectiveList) string {
+		lines := []string{}
+		if description = strings.TrimSpace(description); description != "" {
+				lines = append(lines, strings.Split(description, "\n")...)
+		}
 
-		var sb strings.Builder
+		if deprecated := directives.ForName("deprecated"); deprecated != nil {
+				// The default reason of the specification.
+				reason := "No longer supported"
+				if arg := deprecated.Arguments.ForName("reason"); arg != nil && arg.Value != nil && arg.Value.Raw != "" {
+						reason = strings.Join(strings.Fields(arg.Value.Raw), " ")
+				}
 
-		// this recursion feels overcomplicated
-		for _, selection := range selectionSet {
-				switch selection := selection.(type) {
-				case *ast.Field:
-						for i := 0; i <= depth; i++ {
-								sb.WriteString("    ")
-						}
+				if len(lines) > 0 {
+						lines = append(lines, "")
+				}
+				lines = append(lines, "Deprecated: " + reason)
+		}
 
-						// FIXME: Does not take aliases into account
+		var sb strings.Builder
+		for _, line := range lines {
+				line = strings.TrimRight(line, " \t\r")
+				if line == "" {
+						sb.WriteString("//\n")
+				} else {
+						sb.WriteString("// " + line + "\n")
+				}
+		}
 
-						if len(selection.SelectionSet) == 0 {
-								sb.WriteString(
-										strings.Title(selection.Name) + " " + formatType(selection.Definition.Type) + " `json:\"" + selection.Name + "\"`\n",
-								)
-						} else {
-								sb.WriteString(strings.Title(selection.Name) + " ")
+		return sb.String()
+}
 
-								if !selection.Definition.Type.NonNull {
-										sb.WriteString("*")
-								}
+// formatSourceComment returns the # comment right above a definition of a
+// query document as a Go comment.
+func formatSourceComment(position *ast.Position) string {
+		if position == nil || position.Src == nil {
+				return ""
+		}
 
-								if selection.Definition.Type.Elem != nil {
-										sb.WriteString("[]")
-								}
+		lines := strings.Split(position.Src.Input, "\n")
+		comment := []string{}
+		for i := position.Line - 2; i >= 0 && i < len(lines); i-- {
+				line := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(line, "#") {
+						break
+				}
 
-								sb.WriteString(
-										"struct {\n" + formatSelectionSet(selection.SelectionSet, depth + 1),
-								)
+				line = strings.TrimPrefix(strings.TrimPrefix(line, "#"), " ")
+				comment = append([]string{line}, comment...)
+		}
 
-								for i := 0; i <= depth; i++ {
-										sb.WriteString("    ")
-								}
+		return formatDoc(strings.Join(comment, "\n"), nil)
+}
 
-								sb.WriteString(
-										"} `json:\"" + selection.Name + "\"`\n",
-								)
-						}
-				case *ast.FragmentSpread:
-						if len(selection.Definition.SelectionSet) > 0 {
-								sb.WriteString(
-										formatSelectionSet(selection.Definition.SelectionSet, depth),
-								)
-						}
-				case *ast.InlineFragment:
-						if len(selection.SelectionSet) > 0 {
-								sb.WriteString(
-										formatSelectionSet(selection.SelectionSet, depth),
-								)
-						}
-				default:
+// isOptionalVariable tells whether a variable may be omitted, which makes the
+// server use its default value.
+func isOptionalVariable(variable *ast.VariableDefinition) bool {
+		return !variable.Type.NonNull || variable.DefaultValue != nil
+}
 
-				}
+// formatVariableType returns the Go type of a variable. Optional variables are
+// pointers, which are omitted when nil.
+func formatVariableType(variable *ast.VariableDefinition) string {
+		if variable.Type.NonNull && variable.DefaultValue != nil {
+				nullable := *variable.Type
+				nullable.NonNull = false
+				return formatType(&nullable)
 		}
 
-		return sb.String()
+		return formatType(variable.Type)
+}
+
+// operationType returns the name of the root type an operation selects from.
+func operationType(schema *ast.Schema, op *ast.OperationDefinition) string {
+		switch op.Operation {
+		case ast.Mutation:
+				return schema.Mutation.Name
+		case ast.Subscription:
+				return schema.Subscription.Name
+		default:
+				return schema.Query.Name
+		}
 }
 
-func formatQuery(op *ast.OperationDefinition, fragments ast.FragmentDefinitionList) string {
+// formatQuery prints op followed by the fragments it uses, directly or
+// through other fragments.
+func formatQuery(op *ast.OperationDefinition) string {
 		var sb strings.Builder
 
 		f := Formatter{Writer: &sb}
 		f.FormatOperationDefinition(op)
 
+		used := ast.FragmentDefinitionList{}
+		seen := make(map[string]bool)
+
+		var collect func(selectionSet ast.SelectionSet)
+		collect = func(selectionSet ast.SelectionSet) {
+				for _, selection := range selectionSet {
+						switch selection := selection.(type) {
+						case *ast.Field:
+								collect(selection.SelectionSet)
+						case *ast.InlineFragment:
+								collect(selection.SelectionSet)
+						case *ast.FragmentSpread:
+								if !seen[selection.Name] {
+										seen[selection.Name] = true
+										used = append(used, selection.Definition)
+										collect(selection.Definition.SelectionSet)
+								}
+						}
+				}
+		}
+		collect(op.SelectionSet)
+
+		for _, fragment := range used {
+				f.WriteNewline()
+				f.FormatFragmentDefinition(fragment)
+		}
+
 		return sb.String()
 }
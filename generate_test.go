@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of the generated code")
+
+// testGolden generates the project of testdata/name, from its schema.graphql
+// and the operations of its ops directory, and compares every generated file
+// to its .golden copy in testdata/name.
+func testGolden(t *testing.T, name string, project Project) {
+		t.Helper()
+
+		dir := filepath.Join("testdata", name)
+		output := t.TempDir()
+
+		project.Schema = stringList{filepath.Join(dir, "schema.graphql")}
+		project.Operations = stringList{filepath.Join(dir, "ops", "*.graphql")}
+		project.Package = "client"
+		project.Output = output
+		project.Split = SPLIT_KIND
+
+		if err := generateProject(&project); err != nil {
+				t.Fatal(err)
+		}
+
+		files, err := filepath.Glob(filepath.Join(output, "*.go"))
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		for _, file := range files {
+				got, err := ioutil.ReadFile(file)
+				if err != nil {
+						t.Fatal(err)
+				}
+
+				golden := filepath.Join(dir, filepath.Base(file) + ".golden")
+				if *update {
+						if err = ioutil.WriteFile(golden, got, 0644); err != nil {
+								t.Fatal(err)
+						}
+						continue
+				}
+
+				want, err := ioutil.ReadFile(golden)
+				if os.IsNotExist(err) {
+						t.Errorf("%s was generated but has no golden file, run go test -update", filepath.Base(file))
+						continue
+				} else if err != nil {
+						t.Fatal(err)
+				}
+
+				if !bytes.Equal(got, want) {
+						t.Errorf("%s differs from %s, run go test -update and review the diff:\n%s", filepath.Base(file), golden, got)
+				}
+		}
+}
+
+func TestFormatType(t *testing.T) {
+		named := ast.NamedType
+		nonNull := func(name string) *ast.Type { return ast.NonNullNamedType(name, nil) }
+		list := func(elem *ast.Type) *ast.Type { return ast.ListType(elem, nil) }
+		nonNullList := func(elem *ast.Type) *ast.Type { return ast.NonNullListType(elem, nil) }
+
+		tests := []struct {
+				graphql	string
+				t				*ast.Type
+				goType	string
+		}{
+				{"Int", named("Int", nil), "*int64"},
+				{"Int!", nonNull("Int"), "int64"},
+				{"[String]", list(named("String", nil)), "*[]*string"},
+				{"[String!]!", nonNullList(nonNull("String")), "[]string"},
+				{"[[Int!]]", list(list(nonNull("Int"))), "*[]*[]int64"},
+				{"[[Int]!]!", nonNullList(nonNullList(named("Int", nil))), "[][]*int64"},
+				{"[[Point!]!]", list(nonNullList(nonNull("Point"))), "*[][]Point"},
+				{"[[[order_by]]]", list(list(list(named("order_by", nil)))), "*[]*[]*[]*OrderBy"},
+		}
+
+		for _, test := range tests {
+				if goType := formatType(test.t); goType != test.goType {
+						t.Errorf("formatType(%s) = %s, want %s", test.graphql, goType, test.goType)
+				}
+				if test.t.String() != test.graphql {
+						t.Errorf("test type %s is built as %s", test.graphql, test.t.String())
+				}
+		}
+
+		if goType := formatTypeWith(list(nonNullList(nonNull("Point"))), "struct{}"); goType != "*[][]struct{}" {
+				t.Errorf("formatTypeWith([[Point!]!], struct{}) = %s, want *[][]struct{}", goType)
+		}
+}
+
+// TestListTypes covers the shapes of TestFormatType in input fields, schema
+// fields, selections and variables.
+func TestListTypes(t *testing.T) {
+		full := true
+		testGolden(t, "lists", Project{Full: &full})
+}
+
+// TestSubscriptions covers the subscription methods, along with the type of
+// the default subscription root, Subscription, which the runtime must not
+// declare.
+func TestSubscriptions(t *testing.T) {
+		full := true
+		testGolden(t, "subscriptions", Project{Full: &full})
+}
+
+// TestValidation covers the Validate methods of inputs and variables, which
+// fields named validate must not replace, and the required input fields sent
+// even when zero.
+func TestValidation(t *testing.T) {
+		testGolden(t, "validation", Project{})
+}
+
+func TestQualifyType(t *testing.T) {
+		tests := []struct {
+				mapping		string
+				goType		string
+				// imports are the import paths knownImports gets, by package name.
+				imports		map[string]string
+				err				string
+		}{
+				{mapping: "int64", goType: "int64"},
+				{mapping: "[]string", goType: "[]string"},
+				{mapping: "time.Time", goType: "time.Time", imports: map[string]string{"time": "time"}},
+				{mapping: "*time.Time", goType: "*time.Time", imports: map[string]string{"time": "time"}},
+				{mapping: "json.RawMessage", goType: "json.RawMessage", imports: map[string]string{"json": "encoding/json"}},
+				{mapping: "[]encoding/json.RawMessage", goType: "[]json.RawMessage", imports: map[string]string{"json": "encoding/json"}},
+				{mapping: "math/big.Int", goType: "big.Int", imports: map[string]string{"big": "math/big"}},
+				{mapping: " github.com/google/uuid.UUID ", goType: "uuid.UUID", imports: map[string]string{"uuid": "github.com/google/uuid"}},
+				{mapping: "[]*github.com/shopspring/decimal.Decimal", goType: "[]*decimal.Decimal", imports: map[string]string{"decimal": "github.com/shopspring/decimal"}},
+				{mapping: "github.com/jackc/pgx/v5/pgtype.Numeric", goType: "pgtype.Numeric", imports: map[string]string{"pgtype": "github.com/jackc/pgx/v5/pgtype"}},
+				{mapping: "github.com/example/money/v2.Amount", goType: "money.Amount", imports: map[string]string{"money": "github.com/example/money/v2"}},
+				{mapping: "big.Int", err: "give its import path"},
+				{mapping: "decimal.Decimal", err: "give its import path"},
+				{mapping: "github.com/google/uuid.", err: "invalid type"},
+				{mapping: "github.com/other/fmt.Printer", err: "already used by fmt"},
+		}
+
+		for _, test := range tests {
+				if err := configureScalars(nil); err != nil {
+						t.Fatal(err)
+				}
+
+				goType, err := qualifyType(test.mapping)
+				if test.err != "" {
+						if err == nil || !strings.Contains(err.Error(), test.err) {
+								t.Errorf("qualifyType(%s) err = %v, want %s", test.mapping, err, test.err)
+						}
+						continue
+				} else if err != nil {
+						t.Errorf("qualifyType(%s) err = %v", test.mapping, err)
+						continue
+				}
+
+				if goType != test.goType {
+						t.Errorf("qualifyType(%s) = %s, want %s", test.mapping, goType, test.goType)
+				}
+				for name, path := range test.imports {
+						if knownImports[name] != path {
+								t.Errorf("qualifyType(%s) imports %s as %s, want %s", test.mapping, name, knownImports[name], path)
+						}
+				}
+		}
+
+		if err := configureScalars(map[string]string{"uuid": "github.com/google/uuid.UUID", "guid": "github.com/satori/uuid.UUID"}); err == nil {
+				t.Errorf("two packages named uuid were both imported")
+		}
+}
+
+func TestImportName(t *testing.T) {
+		tests := map[string]string{
+				"time": "time",
+				"encoding/json": "json",
+				"github.com/google/uuid": "uuid",
+				"github.com/jackc/pgx/v5": "pgx",
+				"github.com/jackc/pgx/v5/pgtype": "pgtype",
+				"gopkg.in/yaml.v2": "yaml",
+				"github.com/mattn/go-sqlite3": "sqlite3",
+				"github.com/example/json-iterator": "jsoniterator",
+				"v2": "v2",
+		}
+
+		for path, name := range tests {
+				if importName(path) != name {
+						t.Errorf("importName(%s) = %s, want %s", path, importName(path), name)
+				}
+		}
+}
+
+// TestScalars covers the types mapped to scalars, whose declarations keep the
+// GraphQL name of the scalar, and alias the types of other packages.
+func TestScalars(t *testing.T) {
+		testGolden(t, "scalars", Project{
+				Scalars: map[string]string{
+						"uuid": "github.com/google/uuid.UUID",
+						"timestamptz": "time.Time",
+						"bigint": "int64",
+						"numeric": "math/big.Float",
+				},
+		})
+}
+
+func TestResolveScalars(t *testing.T) {
+		schema := gqlparser.MustLoadSchema(&ast.Source{Name: "schema.graphql", Input: `
+				scalar bigint
+				scalar jsonb
+				scalar unused
+				type Query { count(filter: Filter): bigint }
+				input Filter { data: jsonb }
+		`})
+
+		queryDoc, err := parseQueryDocuments(schema, []*ast.Source{{Name: "count.graphql", Input: `
+				query Count($filter: Filter) { count(filter: $filter) }
+		`}})
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		tests := []struct {
+				mode			string
+				scalars		map[string]string
+				goType		string
+				err				[]string
+		}{
+				{mode: "", goType: "string"},
+				{mode: UNMAPPED_STRING, goType: "string"},
+				{mode: UNMAPPED_RAW, goType: "json.RawMessage"},
+				{
+						mode: UNMAPPED_STRICT,
+						err: []string{
+								"bigint: used by Count.count",
+								"jsonb: used by Filter.data",
+								"unused: not used by the generated code",
+						},
+				},
+				{
+						mode: UNMAPPED_STRICT,
+						scalars: map[string]string{"bigint": "int64", "jsonb": "json.RawMessage", "unused": "string"},
+				},
+				{mode: "lenient", err: []string{"unknown unmapped scalar mode lenient"}},
+		}
+
+		for _, test := range tests {
+				if err := configureScalars(test.scalars); err != nil {
+						t.Fatal(err)
+				}
+
+				err := resolveScalars(schema, queryDoc, false, test.mode)
+				if len(test.err) > 0 {
+						for _, message := range test.err {
+								if err == nil || !strings.Contains(err.Error(), message) {
+										t.Errorf("mode %s: err = %v, want %s", test.mode, err, message)
+								}
+						}
+						continue
+				} else if err != nil {
+						t.Errorf("mode %s: err = %v", test.mode, err)
+						continue
+				}
+
+				if test.goType != "" && (typeMap["bigint"] != test.goType || typeMap["unused"] != test.goType) {
+						t.Errorf("mode %s: unmapped scalars are %s and %s, want %s", test.mode, typeMap["bigint"], typeMap["unused"], test.goType)
+				}
+		}
+}
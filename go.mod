@@ -4,5 +4,7 @@ go 1.16
 
 require (
 	github.com/agnivade/levenshtein v1.1.1 // indirect
+	github.com/gorilla/websocket v1.5.0
 	github.com/vektah/gqlparser/v2 v2.2.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -79,6 +79,9 @@ func isGoFile(f fs.DirEntry) bool {
 
 // If in == nil, the source is the contents of the file with the given filename.
 func ProcessFile(filename string, in io.Reader, out io.Writer, stdin bool) error {
+	// Callers outside of gofmtMain still need comments to be kept.
+	initParserMode()
+
 	var perm fs.FileMode = 0644
 	if in == nil {
 		f, err := os.Open(filename)
@@ -9,8 +9,10 @@ import (
 
 	"io/ioutil"
 	"net/http"
+	"os"
 
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
 )
 
 type DefinitionKind string
@@ -35,18 +37,22 @@ const (
 		TYPE_NON_NULL				TypeKind = "NON_NULL"
 )
 
+type IntrospectionSchema struct {
+		QueryType						*FullType		`json:"queryType"`
+		MutationType				*FullType		`json:"mutationType"`
+		SubscriptionType		*FullType		`json:"subscriptionType"`
+		Types								[]*FullType `json:"types"`
+}
+
+// IntrospectionQueryResult accepts both the full response to the
+// introspection query and a bare `{"__schema": ...}` object, which is how
+// many tools save it to disk.
 type IntrospectionQueryResult struct {
 		Data struct {
-				Schema struct {
-						QueryType						*FullType		`json:"queryType"`
-						MutationType				*FullType		`json:"mutationType"`
-						SubscriptionType		*FullType		`json:"subscriptionType"`
-						Types								[]*FullType `json:"types"`
-				} `json:"__schema"`
+				Schema *IntrospectionSchema `json:"__schema"`
 		} `json:"data"`
-		Errors []struct{
-				Message string `json:"message"`
-		} `json:"errors"`
+		Schema *IntrospectionSchema `json:"__schema"`
+		Errors GraphQLErrors `json:"errors"`
 }
 
 type FullType struct {
@@ -61,15 +67,19 @@ type FullType struct {
 }
 
 type Field struct {
-		Name				string					`json:"name"`
-		Description string					`json:"description"`
-		Args				[]*InputValue		`json:"args"`
-		Type				*TypeRef				`json:"type"`
+		Name							string					`json:"name"`
+		Description				string					`json:"description"`
+		Args							[]*InputValue		`json:"args"`
+		Type							*TypeRef				`json:"type"`
+		IsDeprecated			bool						`json:"isDeprecated"`
+		DeprecationReason	*string					`json:"deprecationReason"`
 }
 
 type EnumValue struct {
-		Name				string `json:"name"`
-		Description string `json:"description"`
+		Name							string	`json:"name"`
+		Description				string	`json:"description"`
+		IsDeprecated			bool		`json:"isDeprecated"`
+		DeprecationReason	*string	`json:"deprecationReason"`
 }
 
 type InputValue struct {
@@ -85,6 +95,35 @@ type TypeRef struct {
 		OfType	*TypeRef		`json:"ofType"`
 }
 
+// builtinTypes are the scalars every GraphQL server provides. They are marked
+// as builtin so that printing the schema as SDL leaves them out, the same as
+// for a schema loaded with gqlparser.
+var builtinTypes = map[string]bool{
+		"Int":			true,
+		"Float":		true,
+		"String":		true,
+		"Boolean":	true,
+		"ID":				true,
+}
+
+// parseDefaultValue turns the GraphQL literal an introspection result uses for
+// default values back into a value. An empty string means there is no default.
+func parseDefaultValue(raw string) *ast.Value {
+		if raw == "" {
+				return nil
+		}
+
+		doc, err := parser.ParseQuery(&ast.Source{
+				Input: "query($v: Int = " + raw + ") { __typename }",
+		})
+		if err != nil || len(doc.Operations) == 0 {
+				// Keep the literal as is so it is at least printed back verbatim.
+				return &ast.Value{Raw: raw, Kind: ast.EnumValue}
+		}
+
+		return doc.Operations[0].VariableDefinitions[0].DefaultValue
+}
+
 func parseType(typeRef *TypeRef) *ast.Type {
 		if typeRef == nil {
 				return nil
@@ -119,6 +158,24 @@ func parseType(typeRef *TypeRef) *ast.Type {
 		return nil
 }
 
+// parseDeprecation turns the deprecation of a field or enum value into the
+// @deprecated directive a schema file would declare.
+func parseDeprecation(isDeprecated bool, reason *string) ast.DirectiveList {
+		if !isDeprecated {
+				return nil
+		}
+
+		directive := &ast.Directive{Name: "deprecated"}
+		if reason != nil {
+				directive.Arguments = ast.ArgumentList{{
+						Name: "reason",
+						Value: &ast.Value{Kind: ast.StringValue, Raw: *reason},
+				}}
+		}
+
+		return ast.DirectiveList{directive}
+}
+
 func parseFullType(fullType *FullType) *ast.Definition {
 		fields := []*ast.FieldDefinition{}
 		enumValues := []*ast.EnumValueDefinition{}
@@ -130,10 +187,14 @@ func parseFullType(fullType *FullType) *ast.Definition {
 				}
 		}
 
+		// Only unions declare their possible types, those of an interface are the
+		// objects implementing it.
 		possibleTypes := []string{}
-		for _, possibleType := range fullType.PossibleTypes {
-				if possibleType != nil {
-						possibleTypes = append(possibleTypes, possibleType.Name)
+		if fullType.Kind == DEF_UNION {
+				for _, possibleType := range fullType.PossibleTypes {
+						if possibleType != nil {
+								possibleTypes = append(possibleTypes, possibleType.Name)
+						}
 				}
 		}
 
@@ -146,9 +207,7 @@ func parseFullType(fullType *FullType) *ast.Definition {
 										arguments = append(arguments, &ast.ArgumentDefinition{
 												Name: arg.Name,
 												Description: arg.Description,
-												DefaultValue: &ast.Value{
-														Raw: arg.DefaultValue,
-												},
+												DefaultValue: parseDefaultValue(arg.DefaultValue),
 												Type: parseType(arg.Type),
 										})
 
@@ -159,6 +218,7 @@ func parseFullType(fullType *FullType) *ast.Definition {
 										Description: field.Description,
 										Arguments: arguments,
 										Type: parseType(field.Type),
+										Directives: parseDeprecation(field.IsDeprecated, field.DeprecationReason),
 								})
 						}
 						break
@@ -167,9 +227,7 @@ func parseFullType(fullType *FullType) *ast.Definition {
 								fields = append(fields, &ast.FieldDefinition{
 										Name: input.Name,
 										Description: input.Description,
-										DefaultValue: &ast.Value{
-												Raw: input.DefaultValue,
-										},
+										DefaultValue: parseDefaultValue(input.DefaultValue),
 										Type: parseType(input.Type),
 								})
 						}
@@ -179,6 +237,7 @@ func parseFullType(fullType *FullType) *ast.Definition {
 								enumValues = append(enumValues, &ast.EnumValueDefinition{
 										Name: value.Name,
 										Description: value.Description,
+										Directives: parseDeprecation(value.IsDeprecated, value.DeprecationReason),
 								})
 						}
 						break
@@ -188,6 +247,7 @@ func parseFullType(fullType *FullType) *ast.Definition {
 				Kind: ast.DefinitionKind(fullType.Kind),
 				Name: fullType.Name,
 				Description: fullType.Description,
+				BuiltIn: builtinTypes[fullType.Name] || strings.HasPrefix(fullType.Name, "__"),
 				Interfaces: interfaces,
 				Fields: ast.FieldList(fields),
 				EnumValues: ast.EnumValueList(enumValues),
@@ -196,7 +256,33 @@ func parseFullType(fullType *FullType) *ast.Definition {
 }
 
 func Introspect(endpoint string, headers map[string][]string, includeBuiltin bool) (*ast.Schema, error) {
-		fmt.Printf("Pulling remote schema from %s...\n", endpoint)
+		body, err := fetchIntrospection(endpoint, headers)
+		if err != nil {
+				return nil, err
+		}
+
+		return ParseIntrospection(body, includeBuiltin)
+}
+
+// LoadIntrospection reads a saved introspection result from disk and converts
+// it the same way Introspect does with a live response.
+func LoadIntrospection(path string, includeBuiltin bool) (*ast.Schema, error) {
+		fmt.Printf("Loading introspection result from %s...\n", path)
+
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+				return nil, err
+		}
+
+		return ParseIntrospection(body, includeBuiltin)
+}
+
+// fetchIntrospection runs the introspection query against endpoint and returns
+// the raw response body.
+func fetchIntrospection(endpoint string, headers map[string][]string) ([]byte, error) {
+		// Progress goes to stderr so the introspect subcommand can print the
+		// schema itself to stdout.
+		fmt.Fprintf(os.Stderr, "Pulling remote schema from %s...\n", endpoint)
 
 		query := `
 				query IntrospectionQuery {
@@ -229,6 +315,8 @@ func Introspect(endpoint string, headers map[string][]string, includeBuiltin boo
 								type {
 										...TypeRef
 								}
+								isDeprecated
+								deprecationReason
 						}
 						inputFields {
 								...InputValue
@@ -239,6 +327,8 @@ func Introspect(endpoint string, headers map[string][]string, includeBuiltin boo
 						enumValues(includeDeprecated: true) {
 								name
 								description
+								isDeprecated
+								deprecationReason
 						}
 						possibleTypes {
 								...TypeRef
@@ -312,34 +402,61 @@ func Introspect(endpoint string, headers map[string][]string, includeBuiltin boo
 		}
 		defer response.Body.Close()
 
-		body, _ = ioutil.ReadAll(response.Body)
-
-		var result IntrospectionQueryResult
-		err = json.Unmarshal(body, &result)
+		body, err = readResponse(response)
 		if err != nil {
-				fmt.Println(string(body))
 				return nil, err
-		} else if len(result.Errors) > 0 {
-				return nil, errors.New(fmt.Sprintf("%v", result.Errors))
 		}
 
-		resultSchema := result.Data.Schema
-		schema := &ast.Schema{}
+		// ParseIntrospection would only report a decode error for the error page
+		// of a gateway.
+		if !json.Valid(body) {
+				return nil, newHTTPError(response, body, errors.New("response is not JSON"))
+		}
 
-		if resultSchema.QueryType != nil {
-				schema.Query = parseFullType(resultSchema.QueryType)
+		return body, nil
+}
+
+// parseRootType resolves a root operation type. Saved introspection results
+// usually only carry the name of the root types, so the full definition is
+// looked up among the schema types when possible.
+func parseRootType(schema *ast.Schema, fullType *FullType) *ast.Definition {
+		if fullType == nil {
+				return nil
 		}
 
-		if resultSchema.MutationType != nil {
-				schema.Mutation = parseFullType(resultSchema.MutationType)
+		if def, ok := schema.Types[fullType.Name]; ok {
+				return def
+		}
+
+		return parseFullType(fullType)
+}
+
+// ParseIntrospection converts an introspection result into a schema. Both the
+// `{"data": {"__schema": ...}}` response shape and a bare `{"__schema": ...}`
+// object are accepted.
+func ParseIntrospection(body []byte, includeBuiltin bool) (*ast.Schema, error) {
+		var result IntrospectionQueryResult
+		err := json.Unmarshal(body, &result)
+		if err != nil {
+				return nil, fmt.Errorf("invalid introspection result: %v", err)
+		} else if len(result.Errors) > 0 {
+				return nil, result.Errors
 		}
 
-		if resultSchema.SubscriptionType != nil {
-				schema.Subscription = parseFullType(resultSchema.SubscriptionType)
+		resultSchema := result.Data.Schema
+		if resultSchema == nil {
+				resultSchema = result.Schema
 		}
+		if resultSchema == nil {
+				return nil, errors.New("introspection result does not contain __schema")
+		}
+
+		schema := &ast.Schema{}
 
 		schema.Types = make(map[string]*ast.Definition)
 		schema.PossibleTypes = make(map[string][]*ast.Definition)
+		schema.Implements = make(map[string][]*ast.Definition)
+		defs := []*ast.Definition{}
 		for _, fullType := range resultSchema.Types {
 				if fullType != nil {
 						if !includeBuiltin && strings.HasPrefix(fullType.Name, "__") {
@@ -348,9 +465,35 @@ func Introspect(endpoint string, headers map[string][]string, includeBuiltin boo
 
 						def := parseFullType(fullType)
 						schema.Types[fullType.Name] = def
-						schema.AddPossibleType(fullType.Name, def)
+						defs = append(defs, def)
 				}
 		}
 
+		// Link abstract types with their possible types the same way gqlparser
+		// does when loading SDL.
+		for _, def := range defs {
+				switch def.Kind {
+				case ast.Union:
+						for _, name := range def.Types {
+								if possibleType, ok := schema.Types[name]; ok {
+										schema.AddPossibleType(def.Name, possibleType)
+										schema.AddImplements(name, def)
+								}
+						}
+				case ast.Object, ast.InputObject:
+						for _, name := range def.Interfaces {
+								if intf, ok := schema.Types[name]; ok {
+										schema.AddPossibleType(name, def)
+										schema.AddImplements(def.Name, intf)
+								}
+						}
+						schema.AddPossibleType(def.Name, def)
+				}
+		}
+
+		schema.Query = parseRootType(schema, resultSchema.QueryType)
+		schema.Mutation = parseRootType(schema, resultSchema.MutationType)
+		schema.Subscription = parseRootType(schema, resultSchema.SubscriptionType)
+
 		return schema, nil
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testIntrospectionSchema is an introspection __schema whose root types are
+// not named after their operation, as with Hasura.
+const testIntrospectionSchema = `{
+		"queryType": {"name": "query_root"},
+		"mutationType": {"name": "mutation_root"},
+		"subscriptionType": null,
+		"types": [
+				{"kind": "OBJECT", "name": "query_root", "fields": [
+						{"name": "node", "args": [{"name": "id", "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}}], "type": {"kind": "INTERFACE", "name": "Node"}},
+						{"name": "search", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "LIST", "ofType": {"kind": "UNION", "name": "SearchResult"}}}}
+				]},
+				{"kind": "OBJECT", "name": "mutation_root", "fields": [
+						{"name": "delete_user", "args": [{"name": "id", "type": {"kind": "SCALAR", "name": "ID"}, "defaultValue": "\"0\""}], "type": {"kind": "SCALAR", "name": "Boolean"}}
+				]},
+				{"kind": "INTERFACE", "name": "Node", "fields": [
+						{"name": "id", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}}
+				], "possibleTypes": [{"kind": "OBJECT", "name": "User"}]},
+				{"kind": "OBJECT", "name": "User", "fields": [
+						{"name": "id", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}},
+						{"name": "login", "args": [], "type": {"kind": "SCALAR", "name": "String"}, "isDeprecated": true, "deprecationReason": "use name"}
+				], "interfaces": [{"kind": "INTERFACE", "name": "Node"}]},
+				{"kind": "UNION", "name": "SearchResult", "possibleTypes": [{"kind": "OBJECT", "name": "User"}]},
+				{"kind": "ENUM", "name": "role", "enumValues": [{"name": "admin"}, {"name": "user", "isDeprecated": true}]},
+				{"kind": "INPUT_OBJECT", "name": "user_filter", "inputFields": [
+						{"name": "roles", "type": {"kind": "LIST", "ofType": {"kind": "NON_NULL", "ofType": {"kind": "ENUM", "name": "role"}}}}
+				]},
+				{"kind": "SCALAR", "name": "ID"},
+				{"kind": "SCALAR", "name": "String"},
+				{"kind": "SCALAR", "name": "Boolean"},
+				{"kind": "OBJECT", "name": "__Type", "fields": []}
+		]
+}`
+
+func TestParseIntrospection(t *testing.T) {
+		tests := []struct {
+				name						string
+				body						string
+				includeBuiltin	bool
+				err							string
+		}{
+				{name: "response", body: `{"data": {"__schema": ` + testIntrospectionSchema + `}}`},
+				{name: "bare schema", body: `{"__schema": ` + testIntrospectionSchema + `}`},
+				{name: "builtin types", body: `{"__schema": ` + testIntrospectionSchema + `}`, includeBuiltin: true},
+				{name: "errors", body: `{"errors": [{"message": "introspection is disabled"}]}`, err: "introspection is disabled"},
+				{name: "no schema", body: `{"data": {}}`, err: "does not contain __schema"},
+				{name: "not json", body: `<html>`, err: "invalid introspection result"},
+		}
+
+		for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+						schema, err := ParseIntrospection([]byte(test.body), test.includeBuiltin)
+						if test.err != "" {
+								if err == nil || !strings.Contains(err.Error(), test.err) {
+										t.Fatalf("err = %v, want %s", err, test.err)
+								}
+								return
+						} else if err != nil {
+								t.Fatal(err)
+						}
+
+						if schema.Query == nil || schema.Query.Name != "query_root" || len(schema.Query.Fields) != 2 {
+								t.Errorf("Query = %+v, want the query_root type", schema.Query)
+						}
+						if schema.Mutation == nil || schema.Mutation.Name != "mutation_root" {
+								t.Errorf("Mutation = %+v, want the mutation_root type", schema.Mutation)
+						}
+						if schema.Subscription != nil {
+								t.Errorf("Subscription = %+v, want nil", schema.Subscription)
+						}
+
+						if _, ok := schema.Types["__Type"]; ok != test.includeBuiltin {
+								t.Errorf("__Type included = %v, want %v", ok, test.includeBuiltin)
+						}
+						if !schema.Types["ID"].BuiltIn || schema.Types["role"].BuiltIn {
+								t.Errorf("only the standard scalars are builtin")
+						}
+
+						for _, abstract := range []string{"Node", "SearchResult"} {
+								possibleTypes := schema.GetPossibleTypes(schema.Types[abstract])
+								if len(possibleTypes) != 1 || possibleTypes[0].Name != "User" {
+										t.Errorf("possible types of %s = %v, want User", abstract, possibleTypes)
+								}
+						}
+
+						arg := schema.Mutation.Fields.ForName("delete_user").Arguments.ForName("id")
+						if arg.DefaultValue == nil || arg.DefaultValue.Raw != "0" {
+								t.Errorf("default value of id = %v, want \"0\"", arg.DefaultValue)
+						}
+
+						roles := schema.Types["user_filter"].Fields.ForName("roles").Type
+						if roles.String() != "[role!]" {
+								t.Errorf("type of roles = %s, want [role!]", roles)
+						}
+
+						login := schema.Types["User"].Fields.ForName("login")
+						if deprecated := login.Directives.ForName("deprecated"); deprecated == nil || deprecated.Arguments.ForName("reason").Value.Raw != "use name" {
+								t.Errorf("login is not deprecated for its reason")
+						}
+						if schema.Types["role"].EnumValues.ForName("user").Directives.ForName("deprecated") == nil {
+								t.Errorf("role.user is not deprecated")
+						}
+				})
+		}
+}
+
+// TestIntrospectionSDL checks that the SDL the introspect subcommand prints
+// for an introspected schema can be loaded back.
+func TestIntrospectionSDL(t *testing.T) {
+		introspected, err := ParseIntrospection([]byte(`{"__schema": ` + testIntrospectionSchema + `}`), false)
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		var sdl bytes.Buffer
+		NewFormatter(&sdl).FormatSchema(introspected)
+
+		path := filepath.Join(t.TempDir(), "schema.graphql")
+		if err = ioutil.WriteFile(path, sdl.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+		}
+
+		schema, err := LoadSchema([]string{path}, false)
+		if err != nil {
+				t.Fatalf("%v, in:\n%s", err, sdl.String())
+		}
+
+		if schema.Query.Name != "query_root" || schema.Mutation.Name != "mutation_root" {
+				t.Errorf("root types are %s and %s, want query_root and mutation_root", schema.Query.Name, schema.Mutation.Name)
+		}
+		for name, def := range introspected.Types {
+				loaded := schema.Types[name]
+				if loaded == nil || loaded.Kind != def.Kind || len(loaded.Fields) != len(def.Fields) {
+						t.Errorf("%s is loaded as %+v, want %+v", name, loaded, def)
+				}
+		}
+		for _, abstract := range []string{"Node", "SearchResult"} {
+				possibleTypes := schema.GetPossibleTypes(schema.Types[abstract])
+				if len(possibleTypes) != 1 || possibleTypes[0].Name != "User" {
+						t.Errorf("possible types of %s = %v, want User", abstract, possibleTypes)
+				}
+		}
+}
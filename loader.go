@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// expandGlobs resolves glob patterns into the matching file paths, in the
+// order the patterns were given.
+func expandGlobs(patterns []string) ([]string, error) {
+		files := []string{}
+
+		for _, pattern := range patterns {
+				pattern = strings.TrimSpace(pattern)
+				if pattern == "" {
+						continue
+				}
+
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+						return nil, err
+				}
+
+				if len(matches) == 0 {
+						return nil, fmt.Errorf("no files match %s", pattern)
+				}
+
+				files = append(files, matches...)
+		}
+
+		return files, nil
+}
+
+// LoadSchema parses the SDL files matched by the glob patterns into a single
+// schema. The result has the same shape as one returned by Introspect, so
+// builtin introspection types are dropped unless includeBuiltin is set. A
+// single .json file is read as a saved introspection result instead.
+func LoadSchema(patterns []string, includeBuiltin bool) (*ast.Schema, error) {
+		files, err := expandGlobs(patterns)
+		if err != nil {
+				return nil, err
+		}
+
+		if len(files) == 0 {
+				return nil, errors.New("no schema files given")
+		}
+
+		for _, file := range files {
+				if strings.EqualFold(filepath.Ext(file), ".json") {
+						if len(files) > 1 {
+								return nil, fmt.Errorf("%s: an introspection result cannot be combined with other schema files", file)
+						}
+
+						return LoadIntrospection(file, includeBuiltin)
+				}
+		}
+
+		fmt.Printf("Loading schema from %s...\n", strings.Join(files, ", "))
+
+		sources := []*ast.Source{}
+		for _, file := range files {
+				input, err := ioutil.ReadFile(file)
+				if err != nil {
+						return nil, err
+				}
+
+				sources = append(sources, &ast.Source{
+						Name: file,
+						Input: string(input),
+				})
+		}
+
+		schema, gqlErr := gqlparser.LoadSchema(sources...)
+		if gqlErr != nil {
+				return nil, gqlErr
+		}
+
+		if !includeBuiltin {
+				for name := range schema.Types {
+						if strings.HasPrefix(name, "__") {
+								delete(schema.Types, name)
+						}
+				}
+
+				// The validator appends __schema and __type to the query root, which
+				// an introspection result never lists among its fields.
+				if schema.Query != nil {
+						fields := ast.FieldList{}
+						for _, field := range schema.Query.Fields {
+								if !strings.HasPrefix(field.Name, "__") {
+										fields = append(fields, field)
+								}
+						}
+						schema.Query.Fields = fields
+				}
+		}
+
+		return schema, nil
+}
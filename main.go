@@ -2,13 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"modosuite/graphql-codegen-go/gofmt"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 type headers []string
@@ -23,71 +25,335 @@ func (i *headers) Set(value string) error {
 }
 
 var (
+		configPath = flag.String("config", "", "Path to the config file (default graphql-codegen.yml in the working directory)")
+		projectName = flag.String("project", "", "Only generate the named project of the config file")
 		packageName = flag.String("package", "main", "Name of the package to output")
-		schemaPath = flag.String("schema", "", "Path to locate the graphql schema")
+		schemaPath = flag.String("schema", "", "Comma separated globs of graphql SDL files, or a single introspection .json file, to load the schema from instead of the endpoint")
 		operationsGlob = flag.String("operations", "", "Glob to locate the graphql operations")
 		endpoint	= flag.String("E", "", "Endpoint of the api")
 		fullSchema = flag.Bool("full", false, "Include full schema types")
+		outputPath = flag.String("out", "", "File to write, or directory when splitting the output (default schema.go, or the working directory)")
+		unmappedScalars = flag.String("unmapped-scalars", "", "How to generate scalars without a mapping: string (warn), raw (json.RawMessage) or strict (fail)")
+		optionalInputs = flag.String("optional-inputs", "", "How to generate nullable input fields: pointer (omitted when nil), generic (Optional[T], Go 1.18+) or typed (one Optional type per field type)")
+		unknownEnumValues = flag.String("unknown-enum-values", "", "How decoding treats unknown enum values: reject (fail) or keep (forward compatible, IsValid tells them apart)")
+		splitMode = flag.String("split", "", "Split the output into inputs.go, schema.go and operations.go (kind), or into one file per operation (operation)")
+)
+
+const (
+		SPLIT_NONE				= ""
+		SPLIT_KIND				= "kind"
+		SPLIT_OPERATION		= "operation"
 )
 
 var headerList headers
 
+var scalarList headers
+
+func parseHeaders(list headers) (map[string][]string, error) {
+		headerMap := make(map[string][]string)
+		for _, h := range list {
+				slices := strings.SplitN(h, ":", 2)
+				if len(slices) != 2 {
+						return nil, fmt.Errorf("invalid header %s, expected name: value", h)
+				}
+				headerMap[strings.TrimSpace(slices[0])] = []string{strings.TrimSpace(slices[1])}
+		}
+
+		return headerMap, nil
+}
+
+// introspectMain implements the introspect subcommand, which writes the schema
+// of a live endpoint to a file or stdout.
+func introspectMain(args []string) error {
+		flags := flag.NewFlagSet("introspect", flag.ExitOnError)
+
+		var introspectHeaders headers
+		introspectEndpoint := flags.String("E", "", "Endpoint of the api")
+		format := flags.String("format", "", "Output format, either sdl or json (default from the -o extension, otherwise sdl)")
+		output := flags.String("o", "", "Path to write the schema to (default stdout)")
+		flags.Var(&introspectHeaders, "H", "")
+		flags.Parse(args)
+
+		if *format == "" {
+				if strings.EqualFold(filepath.Ext(*output), ".json") {
+						*format = "json"
+				} else {
+						*format = "sdl"
+				}
+		}
+
+		headerMap, err := parseHeaders(introspectHeaders)
+		if err != nil {
+				return err
+		}
+
+		body, err := fetchIntrospection(*introspectEndpoint, headerMap)
+		if err != nil {
+				return err
+		}
+
+		// Parsing also surfaces any errors the endpoint returned.
+		schema, err := ParseIntrospection(body, false)
+		if err != nil {
+				return err
+		}
+
+		var buf bytes.Buffer
+		switch *format {
+		case "sdl":
+				NewFormatter(&buf).FormatSchema(schema)
+		case "json":
+				if err = json.Indent(&buf, body, "", "  "); err != nil {
+						return err
+				}
+				buf.WriteString("\n")
+		default:
+				return fmt.Errorf("unknown format %s", *format)
+		}
+
+		if *output == "" {
+				_, err = os.Stdout.Write(buf.Bytes())
+				return err
+		}
+
+		if err = ioutil.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+				return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Successfully wrote schema to %s!\n", *output)
+
+		return nil
+}
+
+// loadProjects returns the projects to generate: those of the config file if
+// there is one, otherwise a single project described by the flags. Flags set
+// on the command line override the config values of every project.
+func loadProjects() ([]*Project, error) {
+		projects := []*Project{{}}
+
+		path, err := findConfig(*configPath)
+		if err != nil {
+				return nil, err
+		}
+
+		if path != "" {
+				projects, err = LoadConfig(path)
+				if err != nil {
+						return nil, err
+				}
+		}
+
+		if *projectName != "" {
+				selected := []*Project{}
+				for _, project := range projects {
+						if project.Name == *projectName {
+								selected = append(selected, project)
+						}
+				}
+
+				if len(selected) == 0 {
+						return nil, fmt.Errorf("no project named %s in %s", *projectName, path)
+				}
+				projects = selected
+		}
+
+		headerMap, err := parseHeaders(headerList)
+		if err != nil {
+				return nil, err
+		}
+
+		for _, project := range projects {
+				flag.Visit(func(f *flag.Flag) {
+						switch f.Name {
+						case "package":
+								project.Package = *packageName
+						case "schema":
+								project.Schema = strings.Split(*schemaPath, ",")
+						case "operations":
+								project.Operations = stringList{*operationsGlob}
+						case "E":
+								project.Endpoint = *endpoint
+						case "full":
+								project.Full = fullSchema
+						case "out":
+								project.Output = *outputPath
+						case "split":
+								project.Split = *splitMode
+						case "unmapped-scalars":
+								project.UnmappedScalars = *unmappedScalars
+						case "optional-inputs":
+								project.OptionalInputs = *optionalInputs
+						case "unknown-enum-values":
+								project.UnknownEnumValues = *unknownEnumValues
+						}
+				})
+
+				if project.Headers == nil {
+						project.Headers = make(map[string]string)
+				}
+				for name, values := range headerMap {
+						project.Headers[name] = values[0]
+				}
+
+				if project.Scalars == nil {
+						project.Scalars = make(map[string]string)
+				}
+				for _, mapping := range scalarList {
+						slices := strings.SplitN(mapping, "=", 2)
+						if len(slices) != 2 {
+								return nil, fmt.Errorf("invalid scalar mapping %s, expected name=type", mapping)
+						}
+						project.Scalars[strings.TrimSpace(slices[0])] = strings.TrimSpace(slices[1])
+				}
+
+				if project.Package == "" {
+						project.Package = *packageName
+				}
+				if project.Output == "" {
+						if project.Split == SPLIT_NONE {
+								project.Output = "schema.go"
+						} else {
+								project.Output = "."
+						}
+				}
+		}
+
+		return projects, nil
+}
+
 func main() {
+		if len(os.Args) > 1 && os.Args[1] == "introspect" {
+				if err := introspectMain(os.Args[2:]); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+				}
+				return
+		}
+
 		flag.Var(&headerList, "H", "")
+		flag.Var(&scalarList, "scalar", "Map a scalar to a Go type, as name=type (e.g. uuid=github.com/google/uuid.UUID)")
 		flag.Parse()
 
-		var buf bytes.Buffer
+		projects, err := loadProjects()
+		if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+		}
 
-		buf.WriteString(fmt.Sprintf(`
-				package %s
+		for _, project := range projects {
+				if project.Name != "" {
+						fmt.Printf("Generating project %s...\n", project.Name)
+				}
 
-				import (
-						"encoding/json"
-				)
-		`, *packageName))
+				if err = generateProject(project); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+				}
+		}
+}
 
-		headerMap := make(map[string][]string)
-		for _, h := range headerList {
-				slices := strings.Split(h, ":")
-				headerMap[strings.TrimSpace(slices[0])] = []string{strings.TrimSpace(slices[1])}
+func generateProject(project *Project) error {
+		switch project.Split {
+		case SPLIT_NONE, SPLIT_KIND, SPLIT_OPERATION:
+		default:
+				return fmt.Errorf("unknown split mode %s", project.Split)
 		}
 
-		schema, err := Introspect(*endpoint, headerMap, false)
-		if err != nil {
-				fmt.Println(err)
+		if err := configureScalars(project.Scalars); err != nil {
+				return err
 		}
 
-		err = generateInputs(schema, &buf)
-		if err != nil { panic(err) }
+		headerMap := make(map[string][]string)
+		for name, value := range project.Headers {
+				headerMap[name] = []string{value}
+		}
 
-		if *fullSchema {
-				err = generateSchema(schema, &buf)
-				if err != nil { panic(err) }
+		var schema *ast.Schema
+		var err error
+		if len(project.Schema) > 0 {
+				schema, err = LoadSchema(project.Schema, false)
+		} else {
+				schema, err = Introspect(project.Endpoint, headerMap, false)
 		}
+		if err != nil { return err }
 
-		operationFiles, err := filepath.Glob(*operationsGlob)
-		if err != nil { panic(err) }
+		operationFiles := []string{}
+		for _, pattern := range project.Operations {
+				matches, err := filepath.Glob(pattern)
+				if err != nil { return err }
 
-		queryDocs := []string{}
+				operationFiles = append(operationFiles, matches...)
+		}
+
+		queryDocs := []*ast.Source{}
 		for _, file := range operationFiles {
 				opFile, err := ioutil.ReadFile(file)
-				if err != nil { panic(err) }
+				if err != nil { return err }
 
-				queryDocs = append(queryDocs, string(opFile))
+				queryDocs = append(queryDocs, &ast.Source{
+						Name: file,
+						Input: string(opFile),
+				})
 		}
 
 		queryDoc, err := parseQueryDocuments(schema, queryDocs)
-		if err != nil { panic(err) }
+		if err != nil { return err }
+
+		err = resolveScalars(schema, queryDoc, project.Full != nil && *project.Full, project.UnmappedScalars)
+		if err != nil { return err }
 
-		err = generateOperations(schema, queryDoc, &buf)
-		if err != nil { panic(err) }
+		err = configureNames(schema, queryDoc, project.Full != nil && *project.Full, project.Initialisms, project.Names)
+		if err != nil { return err }
 
-		file, err := os.Create("schema.go")
-		if err != nil { panic(err) }
-		defer file.Close()
+		// files maps the names of the files to write onto their content, in the
+		// order given by fileNames.
+		files := make(map[string]*bytes.Buffer)
+		fileNames := []string{}
+		file := func(name string) *bytes.Buffer {
+				if project.Split == SPLIT_NONE {
+						name = project.Output
+				} else {
+						name = filepath.Join(project.Output, name)
+				}
 
-		err = gofmt.ProcessFile("schema.go", &buf, file, false)
-		if err != nil { panic(err) }
+				if _, ok := files[name]; !ok {
+						files[name] = &bytes.Buffer{}
+						fileNames = append(fileNames, name)
+				}
+
+				return files[name]
+		}
+
+		err = generateInputs(schema, project.OptionalInputs, project.UnknownEnumValues, file("inputs.go"))
+		if err != nil { return err }
+
+		if project.Full != nil && *project.Full {
+				err = generateSchema(schema, file("schema.go"))
+				if err != nil { return err }
+		}
+
+		if project.Split == SPLIT_OPERATION {
+				if len(queryDoc.Fragments) > 0 {
+						err = generateOperations(schema, &ast.QueryDocument{Fragments: queryDoc.Fragments}, file("fragments.go"))
+						if err != nil { return err }
+				}
+
+				for _, op := range queryDoc.Operations {
+						err = generateOperations(schema, &ast.QueryDocument{Operations: ast.OperationList{op}}, file(operationFileName(op.Name)))
+						if err != nil { return err }
+				}
+		} else {
+				err = generateOperations(schema, queryDoc, file("operations.go"))
+				if err != nil { return err }
+		}
+
+		for _, name := range fileNames {
+				if err = writeGoFile(name, project.Package, files[name].Bytes()); err != nil {
+						return err
+				}
+
+				fmt.Printf("Successfully generated %s!\n", name)
+		}
 
-		fmt.Println("Successfully generated schema file!")
+		return nil
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+		tests := []struct {
+				list		headers
+				headers	map[string][]string
+				err			bool
+		}{
+				{headers{"Authorization: Bearer a:b"}, map[string][]string{"Authorization": {"Bearer a:b"}}, false},
+				{headers{" X-Hasura-Role :user", "X-Empty:"}, map[string][]string{"X-Hasura-Role": {"user"}, "X-Empty": {""}}, false},
+				{headers{"Authorization"}, nil, true},
+		}
+
+		for _, test := range tests {
+				headerMap, err := parseHeaders(test.list)
+				if (err != nil) != test.err {
+						t.Errorf("parseHeaders(%q) err = %v, want error %v", test.list, err, test.err)
+				}
+				if !test.err && !reflect.DeepEqual(headerMap, test.headers) {
+						t.Errorf("parseHeaders(%q) = %v, want %v", test.list, headerMap, test.headers)
+				}
+		}
+}
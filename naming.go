@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// commonInitialisms are the words written in upper case in Go identifiers, as
+// listed by golint.
+var commonInitialisms = []string{
+		"ACL", "API", "ASCII", "CPU", "CSS", "DNS", "EOF", "GUID", "HTML", "HTTP",
+		"HTTPS", "ID", "IP", "JSON", "LHS", "QPS", "RAM", "RHS", "RPC", "SLA",
+		"SMTP", "SQL", "SSH", "TCP", "TLS", "TTL", "UDP", "UI", "UID", "UUID",
+		"URI", "URL", "UTF8", "VM", "XML", "XMPP", "XSRF", "XSS",
+}
+
+// runtimeNames are declared by client.go and subscription.go, or by every
+// generated inputs file, so generated types must not use them.
+var runtimeNames = []string{
+		"AdminClient", "HeaderProvider", "HeaderProviderFunc", "StaticHeaders",
+		"BearerToken", "HasuraRole", "TokenSource", "WithHeaders",
+		"GraphQLVariables", "NullVariables", "ValidationError", "GraphQLErrorLocation", "GraphQLError",
+		"ErrorCode", "GraphQLErrors", "GraphQLResult", "RetryPolicy", "RetrySafe",
+		"HTTPError", "SubscriptionProtocol", "GraphQLTransportWS", "GraphQLWS",
+		"SubscriptionOptions", "SubscriptionStream",
+		"MakeInt64", "MakeFloat64", "MakeString", "MakeBool",
+		"Optional", "MakeOptional", "NullOptional",
+}
+
+// clientMethods are the methods of AdminClient that operation methods must not
+// replace.
+var clientMethods = []string{"Request", "Subscribe"}
+
+// names holds the Go names of a project. Types, enum constants, fragments,
+// operations and the types of their selections share the package scope and are
+// named once for the whole project, so that splitting the output does not
+// change them.
+var names = newNamer(nil, nil)
+
+type namer struct {
+		initialisms		map[string]bool
+		overrides			map[string]string
+		scope					*scope
+
+		types					map[string]string
+		enumValues		map[string]string
+		fragments			map[string]string
+		operations		map[string]string
+
+		// fields and variables cache the fieldNames of definitions and
+		// operations, which the templates ask for more than once, so that their
+		// warnings are printed once.
+		fields				map[string]map[string]string
+		variables			map[string]map[string]string
+}
+
+func newNamer(initialisms []string, overrides map[string]string) *namer {
+		n := &namer{
+				initialisms: make(map[string]bool),
+				overrides: overrides,
+				scope: newScope(),
+				types: make(map[string]string),
+				enumValues: make(map[string]string),
+				fragments: make(map[string]string),
+				operations: make(map[string]string),
+				fields: make(map[string]map[string]string),
+				variables: make(map[string]map[string]string),
+		}
+
+		for _, initialism := range append(append([]string{}, commonInitialisms...), initialisms...) {
+				n.initialisms[strings.ToUpper(initialism)] = true
+		}
+
+		return n
+}
+
+// configureNames names the types, enum constants, fragments and operations of
+// a project. Objects, interfaces and unions only take a name in the package
+// scope when full generates them. overrides maps a type (users), field
+// (users.created_at), enum value (order_by.asc), operation (GetUsers) or
+// operation variable (GetUsers.$user_id) to the Go name to give it instead.
+func configureNames(schema *ast.Schema, queryDoc *ast.QueryDocument, full bool, initialisms []string, overrides map[string]string) error {
+		for key, name := range overrides {
+				if !token.IsIdentifier(name) || !token.IsExported(name) {
+						return fmt.Errorf("names: %s is not an exported Go identifier for %s", name, key)
+				}
+		}
+
+		names = newNamer(initialisms, overrides)
+
+		scope := names.scope
+		for _, name := range runtimeNames {
+				scope.reserve(name)
+		}
+		for name := range knownImports {
+				scope.reserve(name)
+		}
+
+		typeNames := make([]string, 0, len(schema.Types))
+		for name := range schema.Types {
+				if !strings.HasPrefix(name, "__") {
+						typeNames = append(typeNames, name)
+				}
+		}
+		sort.Strings(typeNames)
+
+		for _, name := range typeNames {
+				goName := names.name(name, name)
+				switch schema.Types[name].Kind {
+				case ast.Scalar:
+						// Scalar types keep their GraphQL name, unless it is the name of a
+						// package generated code imports, as with uuid.
+						if _, ok := overrides[name]; !ok && !scope.taken(name) {
+								goName = name
+						}
+						goName = scope.declare(name, goName)
+				case ast.InputObject, ast.Enum:
+						goName = scope.declare(name, goName)
+				default:
+						if full {
+								goName = scope.declare(name, goName)
+						}
+				}
+				names.types[name] = goName
+		}
+
+		for _, name := range typeNames {
+				def := schema.Types[name]
+				if def.Kind != ast.Enum {
+						continue
+				}
+
+				for _, value := range def.EnumValues {
+						key := name + "." + value.Name
+						goName, ok := overrides[key]
+						if !ok {
+								goName = names.types[name] + names.identifier(value.Name)
+						}
+						names.enumValues[key] = scope.declare(key, goName)
+				}
+		}
+
+		for _, fragment := range queryDoc.Fragments {
+				names.fragments[fragment.Name] = scope.declare("..." + fragment.Name, names.identifier(fragment.Name) + "Fragment")
+		}
+
+		methods := newScope()
+		for _, method := range clientMethods {
+				methods.reserve(method)
+		}
+
+		for _, op := range queryDoc.Operations {
+				// The operation name is the prefix of every type generated for it.
+				suffixes := []string{"Result"}
+				if len(op.VariableDefinitions) > 0 {
+						suffixes = append(suffixes, "Variables")
+				}
+				if op.Operation == ast.Subscription {
+						suffixes = append(suffixes, "Subscription")
+				}
+
+				base := methods.declareWith(op.Name, names.name(op.Name, op.Name), func(name string) bool {
+						for _, suffix := range suffixes {
+								if scope.taken(name + suffix) {
+										return false
+								}
+						}
+						return true
+				})
+
+				for _, suffix := range suffixes {
+						scope.reserve(base + suffix)
+				}
+				names.operations[op.Name] = base
+		}
+
+		return nil
+}
+
+// name returns the override of key, or the identifier for name.
+func (n *namer) name(key string, name string) string {
+		if override, ok := n.overrides[key]; ok {
+				return override
+		}
+
+		return n.identifier(name)
+}
+
+// identifier turns a GraphQL name, in snake_case, camelCase or SCREAMING_CASE,
+// into an exported Go identifier using initialisms, such as UserID for user_id.
+func (n *namer) identifier(name string) string {
+		var sb strings.Builder
+
+		for _, word := range splitWords(name) {
+				upper := strings.ToUpper(word)
+				if n.initialisms[upper] {
+						sb.WriteString(upper)
+				} else {
+						runes := []rune(strings.ToLower(word))
+						runes[0] = unicode.ToUpper(runes[0])
+						sb.WriteString(string(runes))
+				}
+		}
+
+		identifier := sb.String()
+		if identifier == "" || !unicode.IsLetter([]rune(identifier)[0]) {
+				identifier = "X" + identifier
+		}
+
+		return identifier
+}
+
+// splitWords splits a name into words on underscores and other separators, on
+// case changes and after acronyms, as in HTTPServer. Digits stay with the word
+// they follow.
+func splitWords(name string) []string {
+		words := []string{}
+		runes := []rune(name)
+
+		start := -1
+		for i, r := range runes {
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+						if start >= 0 {
+								words = append(words, string(runes[start:i]))
+								start = -1
+						}
+						continue
+				}
+
+				if start >= 0 && unicode.IsUpper(r) {
+						previous := runes[i-1]
+						nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+						if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+								words = append(words, string(runes[start:i]))
+								start = i
+						}
+				}
+
+				if start < 0 {
+						start = i
+				}
+		}
+
+		if start >= 0 {
+				words = append(words, string(runes[start:]))
+		}
+
+		return words
+}
+
+// scope detects the Go names declared twice in the same scope, and gives the
+// later ones a numbered suffix.
+type scope struct {
+		declared	map[string]string
+}
+
+func newScope() *scope {
+		return &scope{declared: make(map[string]string)}
+}
+
+func (s *scope) taken(name string) bool {
+		_, ok := s.declared[name]
+		return ok || token.IsKeyword(name)
+}
+
+func (s *scope) reserve(name string) {
+		s.declared[name] = ""
+}
+
+// declare declares the Go name of the GraphQL element key.
+func (s *scope) declare(key string, name string) string {
+		return s.declareWith(key, name, func(string) bool { return true })
+}
+
+// declareWith declares the Go name of the GraphQL element key, also requiring
+// free to accept it.
+func (s *scope) declareWith(key string, name string, free func(name string) bool) string {
+		declared := name
+		for i := 2; s.taken(declared) || !free(declared); i++ {
+				declared = name + strconv.Itoa(i)
+		}
+
+		if declared != name {
+				owner, ok := s.declared[name]
+				if !ok {
+						fmt.Fprintf(os.Stderr, "Warning: the names generated for %s as %s are taken, generating %s", key, name, declared)
+				} else if owner == "" {
+						fmt.Fprintf(os.Stderr, "Warning: %s cannot be named %s, which is reserved, generating %s", key, name, declared)
+				} else {
+						fmt.Fprintf(os.Stderr, "Warning: %s and %s are both named %s, generating %s for %s", owner, key, name, declared, key)
+				}
+				fmt.Fprintf(os.Stderr, " (set names in the config to choose)\n")
+		}
+
+		s.declared[declared] = key
+		return declared
+}
+
+// declareSelectionType declares name, the type generated for a selection, and
+// the types named after it with suffixes, in the package scope. It returns the
+// name they are given.
+func declareSelectionType(key string, name string, suffixes []string) string {
+		base := names.scope.declareWith(key, name, func(name string) bool {
+				for _, suffix := range suffixes {
+						if names.scope.taken(name + suffix) {
+								return false
+						}
+				}
+				return true
+		})
+
+		for _, suffix := range suffixes {
+				names.scope.reserve(base + suffix)
+		}
+
+		return base
+}
+
+// typeName returns the Go name of a schema type.
+func typeName(name string) string {
+		if goName, ok := names.types[name]; ok {
+				return goName
+		}
+
+		return names.identifier(name)
+}
+
+// enumValueName returns the Go name of the constant of an enum value.
+func enumValueName(enum string, value string) string {
+		if goName, ok := names.enumValues[enum + "." + value]; ok {
+				return goName
+		}
+
+		return typeName(enum) + names.identifier(value)
+}
+
+// operationName returns the Go name of an operation, which prefixes the names
+// of its types.
+func operationName(op *ast.OperationDefinition) string {
+		if goName, ok := names.operations[op.Name]; ok {
+				return goName
+		}
+
+		return names.identifier(op.Name)
+}
+
+// formatFragmentName returns the Go name of the type of a fragment.
+func formatFragmentName(name string) string {
+		if goName, ok := names.fragments[name]; ok {
+				return goName
+		}
+
+		return names.identifier(name) + "Fragment"
+}
+
+// fieldNames returns the Go names of the fields of a struct, keyed by their
+// GraphQL names. The override of a field is keyed by owner.field. Fields may
+// not use the names of the methods of the struct.
+func fieldNames(owner string, fields []string, methods ...string) map[string]string {
+		scope := newScope()
+		for _, method := range methods {
+				scope.reserve(method)
+		}
+
+		goNames := make(map[string]string)
+		for _, field := range fields {
+				key := owner + "." + field
+				goNames[field] = scope.declare(key, names.name(key, field))
+		}
+
+		return goNames
+}
+
+// definitionFieldNames returns the fieldNames of an object, interface or input
+// object.
+func definitionFieldNames(def *ast.Definition) map[string]string {
+		if goNames, ok := names.fields[def.Name]; ok {
+				return goNames
+		}
+
+		fields := []string{}
+		for _, field := range def.Fields {
+				fields = append(fields, field.Name)
+		}
+
+		goNames := fieldNames(def.Name, fields, "MarshalJSON", "UnmarshalJSON", "Validate")
+		names.fields[def.Name] = goNames
+		return goNames
+}
+
+// variableNames returns the fieldNames of the variables struct of an
+// operation, keyed by variable names.
+func variableNames(op *ast.OperationDefinition) map[string]string {
+		if goNames, ok := names.variables[op.Name]; ok {
+				return goNames
+		}
+
+		variables := []string{}
+		for _, variable := range op.VariableDefinitions {
+				variables = append(variables, "$" + variable.Variable)
+		}
+
+		goNames := make(map[string]string)
+		for variable, goName := range fieldNames(op.Name, variables, "NullVariables", "Validate") {
+				goNames[strings.TrimPrefix(variable, "$")] = goName
+		}
+
+		names.variables[op.Name] = goNames
+		return goNames
+}
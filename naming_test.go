@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestSplitWords(t *testing.T) {
+		tests := []struct {
+				name	string
+				words	[]string
+		}{
+				{"user_id", []string{"user", "id"}},
+				{"userId", []string{"user", "Id"}},
+				{"HTTPServer", []string{"HTTP", "Server"}},
+				{"SCREAMING_CASE", []string{"SCREAMING", "CASE"}},
+				{"utf8_text", []string{"utf8", "text"}},
+				{"point2D", []string{"point2", "D"}},
+				{"__typename", []string{"typename"}},
+				{"_", []string{}},
+		}
+
+		for _, test := range tests {
+				if words := splitWords(test.name); !reflect.DeepEqual(words, test.words) {
+						t.Errorf("splitWords(%q) = %q, want %q", test.name, words, test.words)
+				}
+		}
+}
+
+func TestIdentifier(t *testing.T) {
+		n := newNamer([]string{"sku"}, nil)
+
+		tests := []struct {
+				name				string
+				identifier	string
+		}{
+				{"user_id", "UserID"},
+				{"userId", "UserID"},
+				{"url", "URL"},
+				{"avatar_url", "AvatarURL"},
+				{"HTTPServer", "HTTPServer"},
+				{"ORDER_BY", "OrderBy"},
+				{"product_sku", "ProductSKU"},
+				{"type", "Type"},
+				{"_eq", "Eq"},
+				{"1st", "X1st"},
+				{"_", "X"},
+		}
+
+		for _, test := range tests {
+				if identifier := n.identifier(test.name); identifier != test.identifier {
+						t.Errorf("identifier(%q) = %s, want %s", test.name, identifier, test.identifier)
+				}
+		}
+}
+
+func TestScope(t *testing.T) {
+		s := newScope()
+		s.reserve("Request")
+
+		tests := []struct {
+				key			string
+				name		string
+				declared	string
+		}{
+				{"foo_bar", "FooBar", "FooBar"},
+				{"fooBar", "FooBar", "FooBar2"},
+				{"FooBar", "FooBar", "FooBar3"},
+				{"request", "Request", "Request2"},
+				{"func", "func", "func2"},
+		}
+
+		for _, test := range tests {
+				if declared := s.declare(test.key, test.name); declared != test.declared {
+						t.Errorf("declare(%s, %s) = %s, want %s", test.key, test.name, declared, test.declared)
+				}
+		}
+
+		free := func(name string) bool { return name != "Search" }
+		if declared := s.declareWith("Search", "Search", free); declared != "Search2" {
+				t.Errorf("declareWith(Search) = %s, want Search2", declared)
+		}
+}
+
+func TestConfigureNames(t *testing.T) {
+		schema := gqlparser.MustLoadSchema(&ast.Source{Name: "schema.graphql", Input: `
+				type Query { search(text: String): [SearchResult!]! }
+				type Subscription { updated: Boolean }
+				type User { id: ID! }
+				union SearchResult = User
+				input user_input { id: ID! }
+				input UserInput { id: ID! }
+				enum order_by { asc }
+				enum OrderBy { ASC }
+		`})
+
+		queryDoc, err := parseQueryDocuments(schema, []*ast.Source{{Name: "search.graphql", Input: `
+				query Search { search { ... on User { id } } }
+				subscription Updated { updated }
+		`}})
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		tests := []struct {
+				full				bool
+				overrides		map[string]string
+				types				map[string]string
+				enumValues	map[string]string
+				operations	map[string]string
+		}{
+				{
+						full: false,
+						types: map[string]string{"UserInput": "UserInput", "user_input": "UserInput2", "SearchResult": "SearchResult", "Subscription": "Subscription"},
+						enumValues: map[string]string{"OrderBy.ASC": "OrderByAsc", "order_by.asc": "OrderBy2Asc"},
+						operations: map[string]string{"Search": "Search", "Updated": "Updated"},
+				},
+				{
+						full: true,
+						types: map[string]string{"SearchResult": "SearchResult", "Subscription": "Subscription"},
+						operations: map[string]string{"Search": "Search2"},
+				},
+				{
+						full: true,
+						overrides: map[string]string{"SearchResult": "SearchHit", "order_by.asc": "Ascending"},
+						types: map[string]string{"SearchResult": "SearchHit"},
+						enumValues: map[string]string{"order_by.asc": "Ascending"},
+						operations: map[string]string{"Search": "Search"},
+				},
+		}
+
+		for _, test := range tests {
+				if err := configureNames(schema, queryDoc, test.full, nil, test.overrides); err != nil {
+						t.Fatal(err)
+				}
+
+				for name, goName := range test.types {
+						if names.types[name] != goName {
+								t.Errorf("full %v: type %s is named %s, want %s", test.full, name, names.types[name], goName)
+						}
+				}
+				for value, goName := range test.enumValues {
+						if names.enumValues[value] != goName {
+								t.Errorf("full %v: enum value %s is named %s, want %s", test.full, value, names.enumValues[value], goName)
+						}
+				}
+				for op, goName := range test.operations {
+						if names.operations[op] != goName {
+								t.Errorf("full %v: operation %s is named %s, want %s", test.full, op, names.operations[op], goName)
+						}
+				}
+		}
+
+		if err := configureNames(schema, queryDoc, false, nil, map[string]string{"User": "user"}); err == nil {
+				t.Errorf("the unexported name of an override was accepted")
+		}
+}
+
+// TestFieldNamesWarnOnce checks that the warnings of the field names templates
+// ask for several times are printed once.
+func TestFieldNamesWarnOnce(t *testing.T) {
+		schema := gqlparser.MustLoadSchema(&ast.Source{Name: "schema.graphql", Input: `
+				type Query { users(validate: Boolean): [ID!]! }
+				input UserFilter { validate: Boolean }
+		`})
+
+		queryDoc, err := parseQueryDocuments(schema, []*ast.Source{{Name: "users.graphql", Input: `
+				query Users($validate: Boolean) { users(validate: $validate) }
+		`}})
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		if err := configureNames(schema, queryDoc, false, nil, nil); err != nil {
+				t.Fatal(err)
+		}
+
+		r, w, err := os.Pipe()
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		stderr := os.Stderr
+		os.Stderr = w
+		for i := 0; i < 2; i++ {
+				if goName := definitionFieldNames(schema.Types["UserFilter"])["validate"]; goName != "Validate2" {
+						t.Errorf("UserFilter.validate is named %s, want Validate2", goName)
+				}
+				if goName := variableNames(queryDoc.Operations[0])["validate"]; goName != "Validate2" {
+						t.Errorf("Users.$validate is named %s, want Validate2", goName)
+				}
+		}
+		os.Stderr = stderr
+		w.Close()
+
+		output, err := ioutil.ReadAll(r)
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		if warnings := strings.Count(string(output), "Warning:"); warnings != 2 {
+				t.Errorf("%d warnings, want 2:\n%s", warnings, output)
+		}
+}
+
+// TestSelectionTypeNames covers the types of polymorphic selections, which
+// may collide with the types of the schema.
+func TestSelectionTypeNames(t *testing.T) {
+		testGolden(t, "selection_names", Project{})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	goast "go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"modosuite/graphql-codegen-go/gofmt"
+)
+
+// defaultImports maps the package names generated code may refer to onto
+// their import paths.
+var defaultImports = map[string]string{
+		"json":		"encoding/json",
+		"fmt":		"fmt",
+		"context":	"context",
+}
+
+// knownImports extends defaultImports with the packages of the scalar
+// mappings of the project being generated.
+var knownImports = defaultImports
+
+// usedImports returns the import paths of the known packages that body refers
+// to, so that every generated file only imports what it uses.
+func usedImports(packageName string, body []byte) ([]string, error) {
+		src := append([]byte("package "+packageName+"\n"), body...)
+
+		file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+		if err != nil {
+				return nil, err
+		}
+
+		used := make(map[string]bool)
+		goast.Inspect(file, func(node goast.Node) bool {
+				if selector, ok := node.(*goast.SelectorExpr); ok {
+						// Package names are the only identifiers left unresolved
+						// within a single file.
+						if ident, ok := selector.X.(*goast.Ident); ok && ident.Obj == nil {
+								if path, ok := knownImports[ident.Name]; ok {
+										used[path] = true
+								}
+						}
+				}
+
+				return true
+		})
+
+		imports := make([]string, 0, len(used))
+		for path := range used {
+				imports = append(imports, path)
+		}
+		sort.Slice(imports, func(i, j int) bool {
+				if isStandardImport(imports[i]) != isStandardImport(imports[j]) {
+						return isStandardImport(imports[i])
+				}
+				return imports[i] < imports[j]
+		})
+
+		return imports, nil
+}
+
+func isStandardImport(path string) bool {
+		return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+// writeGoFile adds the package clause and imports to body, formats it and
+// writes it to path.
+func writeGoFile(path string, packageName string, body []byte) error {
+		imports, err := usedImports(packageName, body)
+		if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(fmt.Sprintf("package %s\n\n", packageName))
+
+		if len(imports) > 0 {
+				buf.WriteString("import (\n")
+				for i, importPath := range imports {
+						// Standard library packages come first, as goimports groups them.
+						if i > 0 && isStandardImport(imports[i-1]) && !isStandardImport(importPath) {
+								buf.WriteString("\n")
+						}
+						buf.WriteString(fmt.Sprintf("%q\n", importPath))
+				}
+				buf.WriteString(")\n")
+		}
+
+		buf.Write(body)
+
+		var formatted bytes.Buffer
+		err = gofmt.ProcessFile(path, &buf, &formatted, false)
+		if err != nil {
+				return err
+		}
+
+		if dir := filepath.Dir(path); dir != "." {
+				if err = os.MkdirAll(dir, 0755); err != nil {
+						return err
+				}
+		}
+
+		return ioutil.WriteFile(path, formatted.Bytes(), 0644)
+}
+
+// operationFileName returns the name of the file an operation is written to
+// when splitting per operation. The .graphql suffix keeps names such as
+// get_linux.go from being read as build constraints.
+func operationFileName(name string) string {
+		var sb strings.Builder
+
+		runes := []rune(name)
+		for i, r := range runes {
+				if unicode.IsUpper(r) {
+						if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) && runes[i-1] != '_' {
+								sb.WriteRune('_')
+						}
+						sb.WriteRune(unicode.ToLower(r))
+				} else {
+						sb.WriteRune(r)
+				}
+		}
+
+		return sb.String() + ".graphql.go"
+}
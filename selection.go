@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// selectionFormatter generates the Go types of selection sets. Most of them
+// are anonymous structs, but polymorphic selections need named types, which
+// are collected in decls until the template flushes them.
+type selectionFormatter struct {
+		schema *ast.Schema
+		decls strings.Builder
+}
+
+// flush returns the named types generated since the last call.
+func (f *selectionFormatter) flush() string {
+		decls := f.decls.String()
+		f.decls.Reset()
+
+		return decls
+}
+
+// applies tells whether a type condition matches an object of type typeName.
+func (f *selectionFormatter) applies(typeCondition string, typeName string) bool {
+		if typeCondition == "" || typeCondition == typeName {
+				return true
+		}
+
+		def := f.schema.Types[typeCondition]
+		if def == nil {
+				return false
+		}
+
+		for _, possibleType := range f.schema.GetPossibleTypes(def) {
+				if possibleType.Name == typeName {
+						return true
+				}
+		}
+
+		return false
+}
+
+// collectFields flattens the fragments of selectionSet that apply to typeName
+// into a list of fields with one entry per response key. Selections of the
+// same key are merged, as the server merges them into a single value. When
+// embed is set, the spreads of fragments that are not listed in inline are
+// returned instead of being flattened. The response keys, and the ...name of
+// the fragments, that are only selected under @include or @skip are set in the
+// returned map.
+func (f *selectionFormatter) collectFields(selectionSet ast.SelectionSet, typeName string, embed bool, inline map[string]bool) ([]*ast.Field, []*ast.FragmentDefinition, map[string]bool) {
+		fields := []*ast.Field{}
+		byKey := make(map[string]int)
+		fragments := []*ast.FragmentDefinition{}
+		embedded := make(map[string]bool)
+		conditional := make(map[string]bool)
+
+		// select records a selection of key, which stays conditional as long as
+		// every selection of key is.
+		selected := make(map[string]bool)
+		selectKey := func(key string, isConditional bool) {
+				conditional[key] = (conditional[key] || !selected[key]) && isConditional
+				selected[key] = true
+		}
+
+		var collect func(selectionSet ast.SelectionSet, isConditional bool)
+		collect = func(selectionSet ast.SelectionSet, isConditional bool) {
+				for _, selection := range selectionSet {
+						switch selection := selection.(type) {
+						case *ast.Field:
+								selectKey(selection.Alias, isConditional || isConditionalSelection(selection.Directives))
+
+								if i, ok := byKey[selection.Alias]; ok {
+										merged := *fields[i]
+										merged.SelectionSet = append(append(ast.SelectionSet{}, fields[i].SelectionSet...), selection.SelectionSet...)
+										fields[i] = &merged
+								} else {
+										byKey[selection.Alias] = len(fields)
+										fields = append(fields, selection)
+								}
+						case *ast.FragmentSpread:
+								def := selection.Definition
+								if !f.applies(def.TypeCondition, typeName) {
+										continue
+								}
+
+								spreadConditional := isConditional || isConditionalSelection(selection.Directives)
+
+								// A fragment narrowing its own type has no single Go type to embed.
+								if embed && !inline[def.Name] && !narrows(def.SelectionSet, def.TypeCondition) {
+										selectKey("..." + def.Name, spreadConditional)
+
+										if !embedded[def.Name] {
+												embedded[def.Name] = true
+												fragments = append(fragments, def)
+										}
+								} else {
+										collect(def.SelectionSet, spreadConditional)
+								}
+						case *ast.InlineFragment:
+								if f.applies(selection.TypeCondition, typeName) {
+										collect(selection.SelectionSet, isConditional || isConditionalSelection(selection.Directives))
+								}
+						}
+				}
+		}
+		collect(selectionSet, false)
+
+		return fields, fragments, conditional
+}
+
+// isConditionalSelection tells whether the @include or @skip directives of a
+// selection may leave it out of the response, which is not the case of their
+// literal if: true and if: false respectively.
+func isConditionalSelection(directives ast.DirectiveList) bool {
+		for _, directive := range directives {
+				var omittedWhen string
+				switch directive.Name {
+				case "include":
+						omittedWhen = "false"
+				case "skip":
+						omittedWhen = "true"
+				default:
+						continue
+				}
+
+				arg := directive.Arguments.ForName("if")
+				if arg == nil || arg.Value == nil || arg.Value.Kind != ast.BooleanValue || arg.Value.Raw == omittedWhen {
+						return true
+				}
+		}
+
+		return false
+}
+
+// selectFields returns the fields and embedded fragments of the struct for the
+// selections of selectionSet that apply to typeName. Fragments are embedded so
+// that their types can be shared between operations, while encoding/json still
+// decodes them from the flat result. That only works as long as a response key
+// is not selected at the same depth by two embedded fragments, or by a field
+// with a sub-selection beside the fragment; such fragments are flattened into
+// the struct instead. A leaf field selected both directly and by an embedded
+// fragment is left to the fragment. Fragments spread under @include or @skip
+// are embedded as pointers, which encoding/json only allocates when the
+// response has one of their fields, so they are flattened as well when they
+// share a key with the struct.
+func (f *selectionFormatter) selectFields(selectionSet ast.SelectionSet, typeName string) ([]*ast.Field, []*ast.FragmentDefinition, map[string]bool) {
+		inline := make(map[string]bool)
+
+		for {
+				fields, fragments, conditional := f.collectFields(selectionSet, typeName, true, inline)
+
+				direct := make(map[string]*ast.Field)
+				for _, field := range fields {
+						direct[field.Alias] = field
+				}
+
+				changed := false
+				embeddedKeys := make(map[string]bool)
+				for _, fragment := range fragments {
+						fragmentFields, _, _ := f.collectFields(fragment.SelectionSet, fragment.TypeCondition, false, nil)
+
+						for _, field := range fragmentFields {
+								other, isDirect := direct[field.Alias]
+								if embeddedKeys[field.Alias] || (isDirect && (conditional["..." + fragment.Name] || len(other.SelectionSet) > 0 || len(field.SelectionSet) > 0)) {
+										inline[fragment.Name] = true
+										changed = true
+										break
+								}
+						}
+
+						if !inline[fragment.Name] {
+								for _, field := range fragmentFields {
+										embeddedKeys[field.Alias] = true
+								}
+						}
+				}
+
+				if changed {
+						continue
+				}
+
+				selected := []*ast.Field{}
+				for _, field := range fields {
+						if !embeddedKeys[field.Alias] {
+								selected = append(selected, field)
+						}
+				}
+
+				return selected, fragments, conditional
+		}
+}
+
+// formatFieldName returns the Go name of the field generated for a response
+// key. Fields without an alias take the override of the field of their type,
+// as schema structs do.
+func formatFieldName(field *ast.Field) string {
+		if field.Alias == "__typename" {
+				return "Typename"
+		}
+
+		if field.Alias == field.Name && field.ObjectDefinition != nil {
+				return names.name(field.ObjectDefinition.Name + "." + field.Name, field.Alias)
+		}
+
+		return names.identifier(field.Alias)
+}
+
+// formatSelectionSet returns the struct fields for the selections that apply
+// to typeName. Named types are prefixed by path, the name of the enclosing Go
+// type followed by the fields leading to them.
+func (f *selectionFormatter) formatSelectionSet(selectionSet ast.SelectionSet, depth int, typeName string, path string) (string, error) {
+		fields, _, err := f.formatFields(selectionSet, depth, typeName, path)
+		return fields, err
+}
+
+// structField is a field of a generated struct, embedded fragments included.
+type structField struct {
+		name		string
+		goType	string
+}
+
+// formatFields is formatSelectionSet, also returning the fields of the struct.
+func (f *selectionFormatter) formatFields(selectionSet ast.SelectionSet, depth int, typeName string, path string) (string, []structField, error) {
+		if len(selectionSet) == 0 { return "", nil, nil }
+
+		var sb strings.Builder
+		structFields := []structField{}
+
+		// Distinct response keys can still end up with the same Go field name,
+		// such as the aliases userId and UserId.
+		goNames := make(map[string]*ast.Field)
+
+		fields, fragments, conditional := f.selectFields(selectionSet, typeName)
+
+		for _, fragment := range fragments {
+				goName := formatFragmentName(fragment.Name)
+				goNames[goName] = &ast.Field{Alias: "..." + fragment.Name, Position: fragment.Position}
+
+				for i := 0; i <= depth; i++ {
+						sb.WriteString("    ")
+				}
+
+				// encoding/json only allocates an embedded pointer when the response
+				// has one of its fields.
+				goType := goName
+				if conditional["..." + fragment.Name] {
+						goType = "*" + goName
+				}
+				sb.WriteString(goType + "\n")
+				structFields = append(structFields, structField{goName, goType})
+		}
+
+		for _, field := range fields {
+				goName := formatFieldName(field)
+				if other, ok := goNames[goName]; ok {
+						return "", nil, gqlerror.ErrorPosf(
+								field.Position,
+								"%s and %s at line %d both generate the field %s, use another alias",
+								field.Alias, other.Alias, other.Position.Line, goName,
+						)
+				}
+				goNames[goName] = field
+
+				sb.WriteString(formatDoc(field.Definition.Description, field.Definition.Directives))
+				for i := 0; i <= depth; i++ {
+						sb.WriteString("    ")
+				}
+
+				fieldType := field.Definition.Type
+				if conditional[field.Alias] && fieldType.NonNull {
+						nullable := *fieldType
+						nullable.NonNull = false
+						fieldType = &nullable
+				}
+
+				var goType string
+				if len(field.SelectionSet) == 0 {
+						goType = formatType(fieldType)
+				} else if isPolymorphic(f.schema, field) {
+						name, err := f.formatPolymorphicField(field, path + goName)
+						if err != nil {
+								return "", nil, err
+						}
+
+						goType = formatTypeWith(fieldType, name)
+				} else {
+						fields, err := f.formatSelectionSet(field.SelectionSet, depth + 1, field.Definition.Type.Name(), path + goName)
+						if err != nil {
+								return "", nil, err
+						}
+
+						var inner strings.Builder
+						inner.WriteString("struct {\n" + fields)
+
+						for i := 0; i <= depth; i++ {
+								inner.WriteString("    ")
+						}
+
+						inner.WriteString("}")
+
+						goType = formatTypeWith(fieldType, inner.String())
+				}
+
+				sb.WriteString(goName + " " + goType + " `json:\"" + field.Alias + "\"`\n")
+				structFields = append(structFields, structField{goName, goType})
+		}
+
+		return sb.String(), structFields, nil
+}
+
+// formatPolymorphicField generates an interface named name for a polymorphic
+// field, implemented by one struct per possible type of the field and by
+// <name>Unknown, and a <name>Value holder decoding the right struct according
+// to __typename. The interface has getters for the fields all structs share.
+// The name of the holder, which is the Go type of the field, is returned.
+func (f *selectionFormatter) formatPolymorphicField(field *ast.Field, name string) (string, error) {
+		def := f.schema.Types[field.Definition.Type.Name()]
+
+		possibleTypes := []string{}
+		suffixes := map[string]bool{"Value": true}
+		for _, possibleType := range f.schema.GetPossibleTypes(def) {
+				if possibleType.Kind == ast.Object {
+						possibleTypes = append(possibleTypes, possibleType.Name)
+						suffixes[typeName(possibleType.Name)] = true
+				}
+		}
+
+		// Types added to the schema since the client was generated are kept, so
+		// that the server can add them without breaking older clients.
+		unknownSuffix := "Unknown"
+		for i := 2; suffixes[unknownSuffix]; i++ {
+				unknownSuffix = "Unknown" + strconv.Itoa(i)
+		}
+		suffixes[unknownSuffix] = true
+
+		// The types are declared in the package scope, where they may collide with
+		// the types of the schema or of other selections.
+		suffixList := make([]string, 0, len(suffixes))
+		for suffix := range suffixes {
+				suffixList = append(suffixList, suffix)
+		}
+		key := fmt.Sprintf("%s at %s:%d", field.Alias, field.Position.Src.Name, field.Position.Line)
+		name = declareSelectionType(key, name, suffixList)
+		unknown := name + unknownSuffix
+
+		structNames := []string{}
+		structBodies := []string{}
+		structFields := [][]structField{}
+
+		for _, possibleType := range possibleTypes {
+				possibleName := name + typeName(possibleType)
+
+				fields, goFields, err := f.formatFields(field.SelectionSet, 0, possibleType, possibleName)
+				if err != nil {
+						return "", err
+				}
+
+				structNames = append(structNames, possibleName)
+				structBodies = append(structBodies, fields)
+				structFields = append(structFields, goFields)
+		}
+
+		// A type unknown when the client was generated still has the fields
+		// selected on the abstract type itself.
+		fields, goFields, err := f.formatFields(field.SelectionSet, 0, def.Name, unknown)
+		if err != nil {
+				return "", err
+		}
+
+		structNames = append(structNames, unknown)
+		structBodies = append(structBodies, fields)
+		structFields = append(structFields, goFields)
+
+		getters := sharedFields(structFields)
+
+		f.decls.WriteString("// " + name + " is the result of the " + field.Alias + " selection, one of " + strings.Join(possibleTypes, ", ") + ",\n")
+		f.decls.WriteString("// or " + unknown + " for the types added to the schema since.\n")
+		f.decls.WriteString("// Type switch on " + name + "Value.Value to access the concrete type.\n")
+		f.decls.WriteString("type " + name + " interface {\nGetTypename() string\n")
+		for _, getter := range getters {
+				f.decls.WriteString("Get" + getter.name + "() " + getter.goType + "\n")
+		}
+		f.decls.WriteString("is" + name + "()\n}\n\n")
+
+		var cases strings.Builder
+		for i, structName := range structNames {
+				if structName == unknown {
+						f.decls.WriteString("// " + unknown + " is a " + name + " of a type unknown\n")
+						f.decls.WriteString("// when the client was generated, with the fields selected on " + def.Name + ".\n")
+				}
+				f.decls.WriteString("type " + structName + " struct {\n" + structBodies[i] + "}\n\n")
+				f.decls.WriteString("func (v " + structName + ") GetTypename() string { return v.Typename }\n\n")
+				for _, getter := range getters {
+						f.decls.WriteString("func (v " + structName + ") Get" + getter.name + "() " + getter.goType + " { return v." + getter.name + " }\n\n")
+				}
+				f.decls.WriteString("func (" + structName + ") is" + name + "() {}\n\n")
+
+				if structName == unknown {
+						cases.WriteString("case \"\":\nreturn fmt.Errorf(\"missing __typename for " + name + "\")\n")
+						cases.WriteString("default:\n")
+				} else {
+						cases.WriteString("case \"" + possibleTypes[i] + "\":\n")
+				}
+				cases.WriteString("var value " + structName + "\n")
+				cases.WriteString("if err := json.Unmarshal(data, &value); err != nil {\nreturn err\n}\n")
+				cases.WriteString("v.Value = value\n")
+		}
+
+		f.decls.WriteString("// " + name + "Value holds a " + name + ", decoded according to its __typename.\n")
+		f.decls.WriteString("type " + name + "Value struct {\nValue " + name + "\n}\n\n")
+		f.decls.WriteString("func (v *" + name + "Value) UnmarshalJSON(data []byte) error {\n")
+		f.decls.WriteString("var typename struct {\nTypename string `json:\"__typename\"`\n}\n")
+		f.decls.WriteString("if err := json.Unmarshal(data, &typename); err != nil {\nreturn err\n}\n\n")
+		f.decls.WriteString("switch typename.Typename {\n" + cases.String() + "}\n\n")
+		f.decls.WriteString("return nil\n}\n\n")
+		f.decls.WriteString("func (v " + name + "Value) MarshalJSON() ([]byte, error) {\nreturn json.Marshal(v.Value)\n}\n\n")
+
+		return name + "Value", nil
+}
+
+// sharedFields returns the fields of the last of structs, the struct of a type
+// unknown when the client was generated, that all structs have with the same
+// type, and which can get a Get<Field> method.
+func sharedFields(structs [][]structField) []structField {
+		goNames := make(map[string]bool)
+		for _, fields := range structs {
+				for _, field := range fields {
+						goNames[field.name] = true
+				}
+		}
+
+		shared := []structField{}
+		for _, field := range structs[len(structs) - 1] {
+				if field.name == "Typename" || goNames["Get" + field.name] {
+						continue
+				}
+
+				everywhere := true
+				for _, fields := range structs {
+						found := false
+						for _, other := range fields {
+								if other == field {
+										found = true
+										break
+								}
+						}
+						everywhere = everywhere && found
+				}
+
+				if everywhere {
+						shared = append(shared, field)
+				}
+		}
+
+		return shared
+}
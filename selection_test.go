@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// loadTestOperation parses query against the schema of testdata/name, and
+// returns the selection set of its first root field.
+func loadTestOperation(t *testing.T, name string, query string) (*ast.Schema, *ast.Field) {
+		t.Helper()
+
+		sdl, err := ioutil.ReadFile(filepath.Join("testdata", name, "schema.graphql"))
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		schema, gqlErr := gqlparser.LoadSchema(&ast.Source{Name: "schema.graphql", Input: string(sdl)})
+		if gqlErr != nil {
+				t.Fatal(gqlErr)
+		}
+
+		queryDoc, err := parseQueryDocuments(schema, []*ast.Source{{Name: "query.graphql", Input: query}})
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		return schema, queryDoc.Operations[0].SelectionSet[0].(*ast.Field)
+}
+
+func TestSelectFields(t *testing.T) {
+		fragments := map[string]string{
+				"UserParts": `fragment UserParts on User { id name }`,
+				"Names": `fragment Names on User { name }`,
+				"FriendNames": `fragment FriendNames on User { friends { name } }`,
+		}
+
+		tests := []struct {
+				name				string
+				query				string
+				fields			[]string
+				fragments		[]string
+				conditional	[]string
+		}{
+				{
+						name: "embedded",
+						query: ` { user(id: 1) { email ...UserParts } }`,
+						fields: []string{"email"},
+						fragments: []string{"UserParts"},
+				},
+				{
+						name: "leaf selected directly and by a fragment",
+						query: ` { user(id: 1) { id ...UserParts } }`,
+						fields: []string{},
+						fragments: []string{"UserParts"},
+				},
+				{
+						name: "sub-selection selected directly and by a fragment",
+						query: ` { user(id: 1) { friends { id } ...FriendNames } }`,
+						fields: []string{"friends"},
+						fragments: []string{},
+				},
+				{
+						name: "key selected by two fragments",
+						query: ` { user(id: 1) { ...UserParts ...Names } }`,
+						fields: []string{},
+						fragments: []string{"UserParts"},
+				},
+				{
+						name: "inline fragment",
+						query: ` { user(id: 1) { ... on User { email } ...UserParts } }`,
+						fields: []string{"email"},
+						fragments: []string{"UserParts"},
+				},
+				{
+						name: "nested spreads",
+						query: ` { user(id: 1) { ... on User { ...UserParts } } }`,
+						fields: []string{},
+						fragments: []string{"UserParts"},
+				},
+				{
+						name: "conditional field",
+						query: `($x: Boolean!) { user(id: 1) { id name @include(if: $x) email @skip(if: $x) } }`,
+						fields: []string{"id", "name", "email"},
+						fragments: []string{},
+						conditional: []string{"email", "name"},
+				},
+				{
+						name: "literal condition",
+						query: ` { user(id: 1) { name @include(if: true) email @skip(if: false) } }`,
+						fields: []string{"name", "email"},
+						fragments: []string{},
+				},
+				{
+						name: "field also selected unconditionally",
+						query: `($x: Boolean!) { user(id: 1) { name @skip(if: $x) name } }`,
+						fields: []string{"name"},
+						fragments: []string{},
+				},
+				{
+						name: "conditional inline fragment",
+						query: `($x: Boolean!) { user(id: 1) { id ... @include(if: $x) { email } } }`,
+						fields: []string{"id", "email"},
+						fragments: []string{},
+						conditional: []string{"email"},
+				},
+				{
+						name: "conditional spread",
+						query: `($x: Boolean!) { user(id: 1) { email ...UserParts @include(if: $x) } }`,
+						fields: []string{"email"},
+						fragments: []string{"UserParts"},
+						conditional: []string{"...UserParts"},
+				},
+				{
+						name: "conditional spread sharing a key",
+						query: `($x: Boolean!) { user(id: 1) { id ...UserParts @include(if: $x) } }`,
+						fields: []string{"id", "name"},
+						fragments: []string{},
+						conditional: []string{"name"},
+				},
+		}
+
+		for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+						query := "query Test" + test.query
+						for name, fragment := range fragments {
+								if strings.Contains(test.query, "..." + name) {
+										query += "\n" + fragment
+								}
+						}
+
+						schema, field := loadTestOperation(t, "fragments", query)
+
+						f := &selectionFormatter{schema: schema}
+						fields, embedded, conditional := f.selectFields(field.SelectionSet, "User")
+
+						aliases := []string{}
+						for _, field := range fields {
+								aliases = append(aliases, field.Alias)
+						}
+
+						names := []string{}
+						for _, fragment := range embedded {
+								names = append(names, fragment.Name)
+						}
+
+						conditionalKeys := []string{}
+						for key, isConditional := range conditional {
+								if isConditional {
+										conditionalKeys = append(conditionalKeys, key)
+								}
+						}
+						sort.Strings(conditionalKeys)
+
+						if !reflect.DeepEqual(aliases, test.fields) {
+								t.Errorf("fields = %v, want %v", aliases, test.fields)
+						}
+						if !reflect.DeepEqual(names, test.fragments) {
+								t.Errorf("embedded fragments = %v, want %v", names, test.fragments)
+						}
+						if test.conditional == nil {
+								test.conditional = []string{}
+						}
+						if !reflect.DeepEqual(conditionalKeys, test.conditional) {
+								t.Errorf("conditional keys = %v, want %v", conditionalKeys, test.conditional)
+						}
+				})
+		}
+}
+
+// TestSelectionFieldNames covers the overrides of the fields selected without
+// an alias.
+func TestSelectionFieldNames(t *testing.T) {
+		schema, field := loadTestOperation(t, "fragments", ` { user(id: 1) { email contact: email name } }`)
+
+		if err := configureNames(schema, &ast.QueryDocument{}, false, nil, map[string]string{"User.email": "Mail"}); err != nil {
+				t.Fatal(err)
+		}
+
+		f := &selectionFormatter{schema: schema}
+		_, fields, err := f.formatFields(field.SelectionSet, 0, "User", "User")
+		if err != nil {
+				t.Fatal(err)
+		}
+
+		goNames := []string{}
+		for _, field := range fields {
+				goNames = append(goNames, field.name)
+		}
+
+		if want := []string{"Mail", "Contact", "Name"}; !reflect.DeepEqual(goNames, want) {
+				t.Errorf("fields = %v, want %v", goNames, want)
+		}
+}
+
+// TestFragments covers the fragment types embedded in operation results, and
+// the fragments flattened into them.
+func TestFragments(t *testing.T) {
+		testGolden(t, "fragments", Project{})
+}
+
+// TestConditionalSelections covers the fields and fragments selected under
+// @include or @skip, which are nullable in the result.
+func TestConditionalSelections(t *testing.T) {
+		testGolden(t, "conditional", Project{})
+}
+
+// TestPolymorphicSelections covers the types decoded according to __typename,
+// which is added to the selections lacking it.
+func TestPolymorphicSelections(t *testing.T) {
+		testGolden(t, "polymorphic", Project{})
+}
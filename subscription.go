@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SubscriptionProtocol is the WebSocket sub-protocol subscriptions use.
+type SubscriptionProtocol string
+
+const (
+		// GraphQLTransportWS is the protocol of the graphql-ws library.
+		GraphQLTransportWS	SubscriptionProtocol = "graphql-transport-ws"
+		// GraphQLWS is the legacy protocol of subscriptions-transport-ws.
+		GraphQLWS						SubscriptionProtocol = "graphql-ws"
+)
+
+// SubscriptionOptions configures how a client runs subscriptions. Every
+// subscription gets its own connection.
+type SubscriptionOptions struct {
+		// Protocol defaults to GraphQLTransportWS.
+		Protocol		SubscriptionProtocol
+		// Endpoint defaults to the Endpoint of the client, with a ws or wss
+		// scheme.
+		Endpoint		string
+		// Dialer defaults to websocket.DefaultDialer.
+		Dialer			*websocket.Dialer
+
+		// InitPayload returns the payload of the connection_init message, given
+		// the headers of the client. Its default, {"headers": {...}}, is how
+		// Hasura reads them.
+		InitPayload	func(ctx context.Context, header http.Header) (interface{}, error)
+		// AckTimeout is how long to wait for the server to accept the
+		// connection, 10s if zero.
+		AckTimeout	time.Duration
+		// KeepAlive is the interval of the pings sent with GraphQLTransportWS.
+		// When set, a connection on which nothing was received for three
+		// intervals, including the keep-alive messages of GraphQLWS servers, is
+		// considered lost.
+		KeepAlive		time.Duration
+
+		// Reconnect makes lost connections reconnect and subscribe again, after
+		// the delays of the policy. Results sent in the meantime are missed.
+		Reconnect		*RetryPolicy
+}
+
+// Subscribe starts a subscription, which lasts until the server completes it,
+// ctx is done or it is closed.
+func (c *AdminClient) Subscribe(
+		ctx context.Context,
+		query string,
+		variables map[string]interface{},
+) (*SubscriptionStream, error) {
+		ctx, cancel := context.WithCancel(ctx)
+
+		conn, err := c.subscribe(ctx, query, variables)
+		if err != nil {
+				cancel()
+				return nil, err
+		}
+
+		subscription := &SubscriptionStream{
+				client: c,
+				query: query,
+				variables: variables,
+				events: make(chan subscriptionEvent),
+				cancel: cancel,
+				done: make(chan struct{}),
+		}
+		go subscription.run(ctx, conn)
+
+		return subscription, nil
+}
+
+// SubscriptionStream receives the results of a subscription.
+type SubscriptionStream struct {
+		client		*AdminClient
+		query			string
+		variables	map[string]interface{}
+
+		events		chan subscriptionEvent
+		// err tells why events was closed.
+		err				error
+		cancel		context.CancelFunc
+		done			chan struct{}
+}
+
+type subscriptionEvent struct {
+		result	*GraphQLResult
+		err			error
+}
+
+// Next blocks until the next result, returned the same way Request returns
+// them. Once the subscription is over, it returns io.EOF if the server
+// completed it, the error of the context if it was done or closed, or the
+// error that ended the connection.
+func (s *SubscriptionStream) Next() (*GraphQLResult, error) {
+		event, ok := <-s.events
+		if !ok {
+				return nil, s.err
+		}
+
+		return event.result, event.err
+}
+
+// Close stops the subscription and closes its connection.
+func (s *SubscriptionStream) Close() error {
+		s.cancel()
+		<-s.done
+
+		return nil
+}
+
+func (s *SubscriptionStream) run(ctx context.Context, conn *subscriptionConn) {
+		defer close(s.done)
+		defer close(s.events)
+
+		for {
+				err := conn.serve(ctx, s.client.Subscriptions.KeepAlive, s.deliver)
+				conn.close(ctx.Err() != nil)
+
+				if err == nil {
+						s.err = io.EOF
+						return
+				} else if ctx.Err() != nil {
+						s.err = ctx.Err()
+						return
+				}
+
+				conn, err = s.reconnect(ctx, err)
+				if err != nil {
+						s.err = err
+						return
+				}
+		}
+}
+
+// reconnect connects again after the connection was lost with err.
+func (s *SubscriptionStream) reconnect(ctx context.Context, err error) (*subscriptionConn, error) {
+		policy := s.client.Subscriptions.Reconnect
+		if policy == nil {
+				return nil, err
+		}
+
+		for attempt := 1; ; attempt++ {
+				delay, retry := policy.retries(ctx, attempt, err)
+				if !retry {
+						return nil, err
+				}
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+						timer.Stop()
+						return nil, ctx.Err()
+				case <-timer.C:
+				}
+
+				var conn *subscriptionConn
+				conn, err = s.client.subscribe(ctx, s.query, s.variables)
+				if err == nil {
+						return conn, nil
+				}
+		}
+}
+
+func (s *SubscriptionStream) deliver(ctx context.Context, event subscriptionEvent) error {
+		select {
+		case s.events <- event:
+				return nil
+		case <-ctx.Done():
+				return ctx.Err()
+		}
+}
+
+// subscriptionID identifies the single operation of a connection.
+const subscriptionID = "1"
+
+type subscriptionMessage struct {
+		ID			string					`json:"id,omitempty"`
+		Type		string					`json:"type"`
+		Payload	json.RawMessage	`json:"payload,omitempty"`
+}
+
+type subscriptionConn struct {
+		conn			*websocket.Conn
+		protocol	SubscriptionProtocol
+
+		// Writes happen from serve, the keep-alive and shutdown.
+		mu				sync.Mutex
+		closeOnce	sync.Once
+}
+
+// subscribe connects to the server and starts a subscription on the new
+// connection.
+func (c *AdminClient) subscribe(
+		ctx context.Context,
+		query string,
+		variables map[string]interface{},
+) (*subscriptionConn, error) {
+		options := c.Subscriptions
+
+		protocol := options.Protocol
+		if protocol == "" {
+				protocol = GraphQLTransportWS
+		}
+
+		endpoint := options.Endpoint
+		if endpoint == "" {
+				endpoint = c.Endpoint
+				if strings.HasPrefix(endpoint, "https://") {
+						endpoint = "wss://" + strings.TrimPrefix(endpoint, "https://")
+				} else if strings.HasPrefix(endpoint, "http://") {
+						endpoint = "ws://" + strings.TrimPrefix(endpoint, "http://")
+				}
+		}
+
+		header := http.Header{}
+		if err := c.setHeaders(ctx, header); err != nil {
+				return nil, err
+		}
+
+		dialer := websocket.DefaultDialer
+		if options.Dialer != nil {
+				dialer = options.Dialer
+		}
+		withProtocol := *dialer
+		withProtocol.Subprotocols = []string{string(protocol)}
+
+		// The headers are sent both with the handshake and in the connection_init
+		// payload, where most servers expect them.
+		ws, response, err := withProtocol.DialContext(ctx, endpoint, header)
+		if err != nil {
+				if response != nil {
+						body, _ := ioutil.ReadAll(response.Body)
+						return nil, newHTTPError(response, body, err)
+				}
+				return nil, err
+		}
+
+		conn := &subscriptionConn{conn: ws, protocol: protocol}
+
+		var payload interface{}
+		if options.InitPayload != nil {
+				payload, err = options.InitPayload(ctx, header)
+		} else {
+				headers := make(map[string]string)
+				for name := range header {
+						headers[name] = header.Get(name)
+				}
+				payload = map[string]interface{}{"headers": headers}
+		}
+		if err == nil {
+				err = conn.send("", "connection_init", payload)
+		}
+		if err == nil {
+				err = conn.awaitAck(options.AckTimeout)
+		}
+		if err == nil {
+				start := "subscribe"
+				if protocol == GraphQLWS {
+						start = "start"
+				}
+
+				err = conn.send(subscriptionID, start, map[string]interface{}{
+						"query": query,
+						"variables": variables,
+				})
+		}
+		if err != nil {
+				conn.close(false)
+				return nil, err
+		}
+
+		return conn, nil
+}
+
+func (c *subscriptionConn) send(id string, messageType string, payload interface{}) error {
+		message := subscriptionMessage{ID: id, Type: messageType}
+		if payload != nil {
+				var err error
+				if message.Payload, err = json.Marshal(payload); err != nil {
+						return err
+				}
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return c.conn.WriteJSON(message)
+}
+
+func (c *subscriptionConn) awaitAck(timeout time.Duration) error {
+		if timeout <= 0 {
+				timeout = 10 * time.Second
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+
+		for {
+				var message subscriptionMessage
+				if err := c.conn.ReadJSON(&message); err != nil {
+						return err
+				}
+
+				switch message.Type {
+				case "connection_ack":
+						return nil
+				case "ping":
+						if err := c.send("", "pong", nil); err != nil {
+								return err
+						}
+				case "ka":
+				case "connection_error":
+						return fmt.Errorf("connection refused: %s", message.Payload)
+				default:
+						return fmt.Errorf("unexpected %s message before connection_ack", message.Type)
+				}
+		}
+}
+
+// serve delivers the results of the subscription until it is over. It returns
+// nil when the server ended the subscription, or why the connection ended.
+func (c *subscriptionConn) serve(
+		ctx context.Context,
+		keepAlive time.Duration,
+		deliver func(ctx context.Context, event subscriptionEvent) error,
+) error {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		// Closing the connection unblocks the reads below.
+		go func() {
+				select {
+				case <-ctx.Done():
+						c.close(true)
+				case <-stop:
+				}
+		}()
+
+		if keepAlive > 0 && c.protocol == GraphQLTransportWS {
+				go func() {
+						ticker := time.NewTicker(keepAlive)
+						defer ticker.Stop()
+
+						for {
+								select {
+								case <-ticker.C:
+										if c.send("", "ping", nil) != nil {
+												return
+										}
+								case <-stop:
+										return
+								}
+						}
+				}()
+		}
+
+		for {
+				if keepAlive > 0 {
+						c.conn.SetReadDeadline(time.Now().Add(3 * keepAlive))
+				}
+
+				var message subscriptionMessage
+				if err := c.conn.ReadJSON(&message); err != nil {
+						if ctx.Err() != nil {
+								return ctx.Err()
+						}
+						return err
+				}
+
+				var event subscriptionEvent
+				switch message.Type {
+				case "next", "data":
+						var result GraphQLResult
+						if err := json.Unmarshal(message.Payload, &result); err != nil {
+								return err
+						}
+
+						event.result = &result
+						if len(result.Errors) > 0 {
+								event.err = result.Errors
+						}
+				case "error":
+						// graphql-ws sends a list of errors, subscriptions-transport-ws a
+						// single one.
+						var errs GraphQLErrors
+						if json.Unmarshal(message.Payload, &errs) != nil {
+								var err GraphQLError
+								if json.Unmarshal(message.Payload, &err) != nil {
+										return fmt.Errorf("invalid error message: %s", message.Payload)
+								}
+								errs = GraphQLErrors{err}
+						}
+
+						if err := deliver(ctx, subscriptionEvent{err: errs}); err != nil {
+								return err
+						}
+						return nil
+				case "complete":
+						return nil
+				case "ping":
+						var payload interface{}
+						if len(message.Payload) > 0 {
+								payload = message.Payload
+						}
+
+						if err := c.send("", "pong", payload); err != nil {
+								return err
+						}
+						continue
+				case "pong", "ka":
+						continue
+				case "connection_error":
+						return fmt.Errorf("connection error: %s", message.Payload)
+				default:
+						return fmt.Errorf("unexpected %s message", message.Type)
+				}
+
+				if err := deliver(ctx, event); err != nil {
+						return err
+				}
+		}
+}
+
+// close closes the connection, after stopping the subscription if stop is set
+// and the connection is still up.
+func (c *subscriptionConn) close(stop bool) {
+		c.closeOnce.Do(func() {
+				if stop {
+						if c.protocol == GraphQLWS {
+								c.send(subscriptionID, "stop", nil)
+								c.send("", "connection_terminate", nil)
+						} else {
+								c.send(subscriptionID, "complete", nil)
+						}
+				}
+
+				c.mu.Lock()
+				c.conn.WriteControl(
+						websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+						time.Now().Add(time.Second),
+				)
+				c.mu.Unlock()
+
+				c.conn.Close()
+		})
+}